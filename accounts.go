@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// redactedToken is returned instead of the access token in the API.
+const redactedToken = "[REDACTED]"
+
+type accountJSON Account
+
+// MarshalJSON redacts the access token.
+func (a Account) MarshalJSON() ([]byte, error) {
+	j := accountJSON(a)
+	if j.AccessToken != "" {
+		j.AccessToken = redactedToken
+	}
+	return json.Marshal(j)
+}
+
+const (
+	defaultUrlBase    = "https://github.com"
+	defaultApiUrlBase = "https://api.github.com"
+)
+
+func validateAccount(ctx context.Context, a *Account) error {
+	if a.DisplayName == "" {
+		return errors.New("DisplayName is required")
+	}
+	if a.TokenCommandRaw.Valid {
+		var cmd []string
+		if err := json.Unmarshal([]byte(a.TokenCommandRaw.String), &cmd); err != nil {
+			return errors.Errorf("TokenCommand must be a JSON array of strings: %s", a.TokenCommandRaw.String)
+		}
+	}
+	if a.isApp() {
+		if a.AccessToken != "" || a.TokenEnv.Valid || a.TokenCommandRaw.Valid {
+			return errors.New("GitHub App accounts cannot have an access token")
+		}
+		if err := a.appConfig().validate(); err != nil {
+			return err
+		}
+	} else if err := a.tokenRef().validate(); err != nil {
+		return errors.New("Either AccessToken, TokenEnv or TokenCommand is required")
+	}
+	for _, u := range []string{a.UrlBase, a.ApiUrlBase} {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return errors.Errorf("Invalid URL: %q", u)
+		}
+		if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return errors.Errorf("URL must be http or https: %q", u)
+		}
+	}
+	return nil
+}
+
+// CreateAccount creates the account. UrlBase and ApiUrlBase default to GitHub.com.
+func CreateAccount(ctx context.Context, a *Account) error {
+	if a.UrlBase == "" {
+		a.UrlBase = defaultUrlBase
+	}
+	if a.ApiUrlBase == "" {
+		a.ApiUrlBase = defaultApiUrlBase
+	}
+	if err := validateAccount(ctx, a); err != nil {
+		return err
+	}
+	token, err := encryptToken(a.AccessToken)
+	if err != nil {
+		return err
+	}
+	a.AccessToken = token
+	return errors.WithStack(gormConn.Create(a).Error)
+}
+
+// UpdateAccount saves the account, and restarts its workers with the new credentials.
+// Managed accounts are updated only by the config file.
+func UpdateAccount(ctx context.Context, a *Account) error {
+	if err := validateAccount(ctx, a); err != nil {
+		return err
+	}
+	token, err := encryptToken(a.AccessToken)
+	if err != nil {
+		return err
+	}
+	a.AccessToken = token
+	err = txGorm(func(tx *gorm.DB) error {
+		if err := checkUnmanaged(tx, "accounts", a.ID); err != nil {
+			return err
+		}
+		return errors.WithStack(tx.Save(a).Error)
+	})
+	if err != nil {
+		return err
+	}
+	RestartAccountWorkers(a.ID)
+	return nil
+}
+
+// DeleteAccount stops workers of the account, and deletes the account.
+// Its channels and views are deleted by the foreign keys.
+// Issues are kept because other accounts may refer to them.
+// Managed accounts are deleted only by the config file.
+func DeleteAccount(ctx context.Context, accountID int) error {
+	err := txGorm(func(tx *gorm.DB) error {
+		if err := checkUnmanaged(tx, "accounts", accountID); err != nil {
+			return err
+		}
+		res := tx.Exec(`delete from accounts where id = ?`, accountID)
+		if res.Error != nil {
+			return errors.WithStack(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return errors.Errorf("Account %d does not exist", accountID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	StopAccountWorkers(accountID)
+	return nil
+}
+
+// AccountParams is the request body to create or update an account.
+// Empty fields are not changed on update.
+type AccountParams struct {
+	DisplayName string
+	UrlBase     string
+	ApiUrlBase  string
+
+	// One of AccessToken, TokenEnv, TokenCommand and App
+	AccessToken  string
+	TokenEnv     string
+	TokenCommand []string
+	App          *AppConfig
+}
+
+func (p AccountParams) apply(a *Account) error {
+	if p.DisplayName != "" {
+		a.DisplayName = p.DisplayName
+	}
+	if p.UrlBase != "" {
+		a.UrlBase = p.UrlBase
+	}
+	if p.ApiUrlBase != "" {
+		a.ApiUrlBase = p.ApiUrlBase
+	}
+
+	hasToken := (p.AccessToken != "" && p.AccessToken != redactedToken) || p.TokenEnv != "" || len(p.TokenCommand) != 0
+	if p.App != nil {
+		if hasToken {
+			return errors.New("A token and App cannot be used together")
+		}
+		a.setAppConfig(*p.App)
+		return nil
+	}
+	if !hasToken {
+		return nil
+	}
+	a.AppID, a.AppInstallationID, a.AppPrivateKeyFile = sql.NullInt64{}, sql.NullInt64{}, sql.NullString{}
+	token := p.AccessToken
+	if token == redactedToken {
+		token = ""
+	}
+	return a.setTokenRef(TokenRef{Literal: token, Env: p.TokenEnv, Command: p.TokenCommand})
+}
+
+// VerifyAccount checks the credentials with the API, and sets the login of the token owner.
+// A GitHub App has no login, so only an installation token is minted.
+func VerifyAccount(ctx context.Context, a *Account) error {
+	if a.isApp() {
+		// The token is not cached, because the account may not be saved.
+		_, err := (&appTokenSource{ctx: ctx, account: *a}).mint()
+		return err
+	}
+
+	u, resp, err := ghClient(ctx, *a).Users.Get(ctx, "")
+	if err != nil {
+		return errors.Wrap(err, "Cannot verify the access token")
+	}
+	// Fine-grained tokens do not have the header.
+	if scopes, ok := resp.Header[http.CanonicalHeaderKey("X-OAuth-Scopes")]; ok && !hasRepoScope(scopes) {
+		return errors.Errorf("The access token requires the repo scope, but it has %q", strings.Join(scopes, ", "))
+	}
+	a.Login = NullStringJSON{sql.NullString{String: u.GetLogin(), Valid: true}}
+	return nil
+}
+
+func hasRepoScope(headers []string) bool {
+	for _, h := range headers {
+		for _, s := range strings.Split(h, ",") {
+			if strings.TrimSpace(s) == "repo" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Kinds of user actions recorded in the action log
+const (
+	ActionMarkAsRead       = "markAsRead"
+	ActionMarkAsUnread     = "markAsUnread"
+	ActionBulkMarkAsRead   = "bulkMarkAsRead"
+	ActionBulkMarkAsUnread = "bulkMarkAsUnread"
+	ActionSnooze           = "snooze"
+	ActionUnsnooze         = "unsnooze"
+	ActionMute             = "mute"
+	ActionUnmute           = "unmute"
+	// An undo is also recorded as an action, so the log is append-only.
+	ActionUndo = "undo"
+)
+
+const (
+	maxSelectActions = 200
+	// Older actions are pruned from the log.
+	maxActionLogs = 1000
+)
+
+// IssueTriageState is a part of issue state which is changed by user actions.
+type IssueTriageState struct {
+	AlreadyRead bool
+	Muted       bool
+	Snoozed     bool
+	// They are nil if the issue is not snoozed, or is snoozed without them.
+	SnoozeUntil     *string
+	SnoozeUpdatedAt *string
+	// Read states in channels with the channel read mode. The key is channel ID.
+	ChannelReads map[int]bool `json:",omitempty"`
+}
+
+type Action struct {
+	ID        int
+	Kind      string
+	CreatedAt string
+	// ID of the undone action if Kind is "undo"
+	UndoneActionID NullInt64JSON
+	IssueIDs       []int
+}
+
+// RecordAction runs f, and records the states of the issues before and after f.
+// They are done in a transaction, so the log always matches the change by f.
+func RecordAction(ctx context.Context, kind string, issueIDs []int, f func(tx *gorm.DB) error) error {
+	return txGorm(func(tx *gorm.DB) error {
+		before, err := selectIssueTriageStates(ctx, tx, issueIDs)
+		if err != nil {
+			return err
+		}
+		if err := f(tx); err != nil {
+			return err
+		}
+		after, err := selectIssueTriageStates(ctx, tx, issueIDs)
+		if err != nil {
+			return err
+		}
+		_, err = insertAction(ctx, tx, kind, sql.NullInt64{}, before, after)
+		return err
+	})
+}
+
+// UndoActions restores issue states changed by the last n actions, which are not undone yet.
+// Issues changed after the action, for example by fetching, are skipped to keep the newer change.
+// It returns IDs of the issues restored and skipped.
+func UndoActions(ctx context.Context, n int) ([]int, []int, error) {
+	issueIDSet := make(map[int]bool)
+	skippedSet := make(map[int]bool)
+	err := txGorm(func(tx *gorm.DB) error {
+		var actionIDs []int
+		err := tx.Raw(`
+			select id from action_logs
+			where
+				kind != ? AND
+				id NOT IN (select undoneActionID from action_logs where undoneActionID is not null)
+			order by id desc
+			limit ?
+		`, ActionUndo, n).Pluck("id", &actionIDs).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, actionID := range actionIDs {
+			befores, afters, err := selectActionStates(ctx, tx, actionID)
+			if err != nil {
+				return err
+			}
+			issueIDs := make([]int, 0, len(befores))
+			for issueID := range befores {
+				issueIDs = append(issueIDs, issueID)
+			}
+
+			current, err := selectIssueTriageStates(ctx, tx, issueIDs)
+			if err != nil {
+				return err
+			}
+			for issueID, state := range befores {
+				changed, err := triageStateChanged(current[issueID], afters[issueID])
+				if err != nil {
+					return err
+				}
+				if changed {
+					delete(befores, issueID)
+					skippedSet[issueID] = true
+					continue
+				}
+				if err := restoreIssueTriageState(ctx, tx, issueID, state); err != nil {
+					return err
+				}
+				issueIDSet[issueID] = true
+			}
+			_, err = insertAction(ctx, tx, ActionUndo, sql.NullInt64{Valid: true, Int64: int64(actionID)}, current, befores)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]int, 0, len(issueIDSet))
+	for id := range issueIDSet {
+		res = append(res, id)
+	}
+	skipped := make([]int, 0, len(skippedSet))
+	for id := range skippedSet {
+		if !issueIDSet[id] {
+			skipped = append(skipped, id)
+		}
+	}
+	return res, skipped, nil
+}
+
+// SelectActions returns the latest actions. limit is clamped to 1..maxSelectActions.
+func SelectActions(ctx context.Context, limit int) ([]*Action, error) {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > maxSelectActions {
+		limit = maxSelectActions
+	}
+	res := make([]*Action, 0)
+	rows, err := gormConn.Raw(`
+		select id, kind, createdAt, undoneActionID
+		from action_logs
+		order by id desc
+		limit ?
+	`, limit).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	actionMap := make(map[int]*Action)
+	actionIDs := make([]int, 0)
+	for rows.Next() {
+		a := &Action{IssueIDs: []int{}}
+		if err := rows.Scan(&a.ID, &a.Kind, &a.CreatedAt, &a.UndoneActionID); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, a)
+		actionMap[a.ID] = a
+		actionIDs = append(actionIDs, a.ID)
+	}
+
+	rows, err = gormConn.Raw(`
+		select actionID, issueID
+		from action_log_entries
+		where actionID IN (?)
+	`, actionIDs).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var actionID, issueID int
+		if err := rows.Scan(&actionID, &issueID); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		actionMap[actionID].IssueIDs = append(actionMap[actionID].IssueIDs, issueID)
+	}
+
+	return res, nil
+}
+
+func insertAction(ctx context.Context, tx *gorm.DB, kind string, undoneActionID sql.NullInt64, before, after map[int]*IssueTriageState) (int, error) {
+	err := tx.Exec(`
+		insert into action_logs
+		(kind, createdAt, undoneActionID)
+		values (?, ?, ?)
+	`, kind, fmtTime(time.Now().UTC()), undoneActionID).Error
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	var actionID int
+	if err := tx.Raw(`select last_insert_rowid()`).Row().Scan(&actionID); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if err := pruneActions(tx, actionID-maxActionLogs); err != nil {
+		return 0, err
+	}
+
+	for issueID, b := range before {
+		a, ok := after[issueID]
+		if !ok {
+			continue
+		}
+		beforeJSON, err := json.Marshal(b)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		afterJSON, err := json.Marshal(a)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		err = tx.Exec(`
+			insert into action_log_entries
+			(actionID, issueID, before, after)
+			values (?, ?, ?, ?)
+		`, actionID, issueID, string(beforeJSON), string(afterJSON)).Error
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	return actionID, nil
+}
+
+// pruneActions deletes actions up to the ID, with undo actions of them.
+func pruneActions(tx *gorm.DB, lastID int) error {
+	if lastID < 1 {
+		return nil
+	}
+	err := tx.Exec(`
+		delete from action_log_entries
+		where actionID IN (select id from action_logs where id <= ? OR undoneActionID <= ?)
+	`, lastID, lastID).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = tx.Exec(`delete from action_logs where id <= ? OR undoneActionID <= ?`, lastID, lastID).Error
+	return errors.WithStack(err)
+}
+
+// selectActionStates returns the states before the action, and the JSON of the states after the action.
+func selectActionStates(ctx context.Context, tx *gorm.DB, actionID int) (map[int]*IssueTriageState, map[int]string, error) {
+	rows, err := tx.Raw(`
+		select e.issueID, e.before, e.after
+		from action_log_entries as e, issues as i
+		where e.issueID = i.id AND e.actionID = ?
+	`, actionID).Rows()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	befores := make(map[int]*IssueTriageState)
+	afters := make(map[int]string)
+	for rows.Next() {
+		var issueID int
+		var before, after string
+		if err := rows.Scan(&issueID, &before, &after); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		s := &IssueTriageState{}
+		if err := json.Unmarshal([]byte(before), s); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		befores[issueID] = s
+		afters[issueID] = after
+	}
+	return befores, afters, nil
+}
+
+// triageStateChanged returns true if the current state is not the state recorded after the action.
+func triageStateChanged(current *IssueTriageState, after string) (bool, error) {
+	b, err := json.Marshal(current)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return string(b) != after, nil
+}
+
+func selectIssueTriageStates(ctx context.Context, c *gorm.DB, issueIDs []int) (map[int]*IssueTriageState, error) {
+	res := make(map[int]*IssueTriageState, len(issueIDs))
+	for _, chunk := range chunkInts(issueIDs) {
+		rows, err := c.Raw(`
+			select
+				i.id, i.alreadyRead, i.muted, s.issueID is not null, s.until, s.updatedAt
+			from
+				issues as i
+				left join snoozes as s on s.issueID = i.id
+			where
+				i.id IN (?)
+		`, chunk).Rows()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for rows.Next() {
+			var issueID int
+			var until, updatedAt sql.NullString
+			s := &IssueTriageState{}
+			if err := rows.Scan(&issueID, &s.AlreadyRead, &s.Muted, &s.Snoozed, &until, &updatedAt); err != nil {
+				rows.Close()
+				return nil, errors.WithStack(err)
+			}
+			if until.Valid {
+				s.SnoozeUntil = &until.String
+			}
+			if updatedAt.Valid {
+				s.SnoozeUpdatedAt = &updatedAt.String
+			}
+			res[issueID] = s
+		}
+		rows.Close()
+
+		rows, err = c.Raw(`
+			select ci.issueID, ci.channelID, min(ci.alreadyRead)
+			from channel_issues as ci, channels as ch
+			where
+				ci.channelID = ch.id AND
+				ch.readMode = ? AND
+				ci.issueID IN (?)
+			group by ci.issueID, ci.channelID
+		`, ReadModeChannel, chunk).Rows()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for rows.Next() {
+			var issueID, channelID int
+			var read bool
+			if err := rows.Scan(&issueID, &channelID, &read); err != nil {
+				rows.Close()
+				return nil, errors.WithStack(err)
+			}
+			s, ok := res[issueID]
+			if !ok {
+				continue
+			}
+			if s.ChannelReads == nil {
+				s.ChannelReads = make(map[int]bool)
+			}
+			s.ChannelReads[channelID] = read
+		}
+		rows.Close()
+	}
+	return res, nil
+}
+
+func restoreIssueTriageState(ctx context.Context, tx *gorm.DB, issueID int, s *IssueTriageState) error {
+	err := tx.Exec(`
+		update issues
+		set alreadyRead = ?, muted = ?
+		where id = ?
+	`, s.AlreadyRead, s.Muted, issueID).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for channelID, read := range s.ChannelReads {
+		err := tx.Exec(`
+			update channel_issues
+			set alreadyRead = ?
+			where issueID = ? AND channelID = ?
+		`, read, issueID, channelID).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if !s.Snoozed {
+		err := tx.Exec(`delete from snoozes where issueID = ?`, issueID).Error
+		return errors.WithStack(err)
+	}
+	err = tx.Exec(`
+		replace into snoozes
+		(issueID, until, updatedAt)
+		values (?, ?, ?)
+	`, issueID, s.SnoozeUntil, s.SnoozeUpdatedAt).Error
+	return errors.WithStack(err)
+}
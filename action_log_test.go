@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func selectTestTriageStates(t *testing.T, issueIDs []int) map[int]*IssueTriageState {
+	t.Helper()
+	s, err := selectIssueTriageStates(context.Background(), gormConn, issueIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestUndoActions(t *testing.T) {
+	ctx := context.Background()
+	until := time.Now().Add(time.Hour)
+	issueIDs := []int{1, 2, 3}
+
+	tests := []struct {
+		name     string
+		kind     string
+		issueIDs []int
+		action   func(tx *gorm.DB) error
+	}{
+		{"read", ActionMarkAsRead, []int{1}, func(tx *gorm.DB) error {
+			return UpdateIssuesAlreadyReadInChannel(ctx, []int{1}, 0, true, tx)
+		}},
+		{"read in channel", ActionMarkAsRead, []int{1}, func(tx *gorm.DB) error {
+			return UpdateIssuesAlreadyReadInChannel(ctx, []int{1}, 1, true, tx)
+		}},
+		{"mute", ActionMute, []int{2}, func(tx *gorm.DB) error {
+			return MuteIssue(ctx, 2, true, tx)
+		}},
+		{"snooze", ActionSnooze, []int{3}, func(tx *gorm.DB) error {
+			return SnoozeIssue(ctx, 3, &until, true, tx)
+		}},
+		{"bulk read", ActionBulkMarkAsRead, issueIDs, func(tx *gorm.DB) error {
+			return UpdateIssuesAlreadyReadInChannel(ctx, issueIDs, 0, true, tx)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			insertTestIssues(t, len(issueIDs))
+			insertTestChannel(t, ReadModeChannel)
+			mustExec(t, `insert into channel_issues (issueID, channelID, queryID) values (1, 1, 1)`)
+
+			before := selectTestTriageStates(t, issueIDs)
+			if err := RecordAction(ctx, tt.kind, tt.issueIDs, tt.action); err != nil {
+				t.Fatal(err)
+			}
+			if reflect.DeepEqual(selectTestTriageStates(t, issueIDs), before) {
+				t.Fatal("the action did not change the issues")
+			}
+
+			restored, skipped, err := UndoActions(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Ints(restored)
+			if !reflect.DeepEqual(restored, tt.issueIDs) || len(skipped) != 0 {
+				t.Errorf("restored %v and skipped %v, want %v", restored, skipped, tt.issueIDs)
+			}
+			if got := selectTestTriageStates(t, issueIDs); !reflect.DeepEqual(got, before) {
+				t.Errorf("got %+v after undo, want %+v", got, before)
+			}
+
+			// The action is already undone, and undo actions are not undone.
+			restored, skipped, err = UndoActions(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(restored) != 0 || len(skipped) != 0 {
+				t.Errorf("the second undo restored %v and skipped %v", restored, skipped)
+			}
+			if got := selectTestTriageStates(t, issueIDs); !reflect.DeepEqual(got, before) {
+				t.Errorf("got %+v after the second undo, want %+v", got, before)
+			}
+		})
+	}
+}
+
+func TestUndoActionsInOrder(t *testing.T) {
+	ctx := context.Background()
+	openTestDB(t)
+	insertTestIssues(t, 1)
+
+	read := func(tx *gorm.DB) error { return UpdateIssuesAlreadyRead(ctx, []int{1}, true, tx) }
+	mute := func(tx *gorm.DB) error { return MuteIssue(ctx, 1, true, tx) }
+	if err := RecordAction(ctx, ActionMarkAsRead, []int{1}, read); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAction(ctx, ActionMute, []int{1}, mute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undoing twice undoes the mute, then the read.
+	for _, want := range []IssueTriageState{{AlreadyRead: true}, {}} {
+		if _, _, err := UndoActions(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if got := selectTestTriageStates(t, []int{1})[1]; !reflect.DeepEqual(*got, want) {
+			t.Errorf("got %+v, want %+v", *got, want)
+		}
+	}
+}
+
+func TestUndoActionsSkipsChangedIssues(t *testing.T) {
+	ctx := context.Background()
+	openTestDB(t)
+	insertTestIssues(t, 2)
+
+	err := RecordAction(ctx, ActionBulkMarkAsRead, []int{1, 2}, func(tx *gorm.DB) error {
+		return UpdateIssuesAlreadyRead(ctx, []int{1, 2}, true, tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Issue 2 is updated by fetching after the action.
+	mustExec(t, `update issues set alreadyRead = 0 where id = 2`)
+
+	restored, skipped, err := UndoActions(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, []int{1}) || !reflect.DeepEqual(skipped, []int{2}) {
+		t.Errorf("restored %v and skipped %v, want [1] and [2]", restored, skipped)
+	}
+}
+
+func TestSelectActions(t *testing.T) {
+	ctx := context.Background()
+	openTestDB(t)
+	insertTestIssues(t, 1)
+
+	for i := 0; i < 3; i++ {
+		err := RecordAction(ctx, ActionMarkAsRead, []int{1}, func(tx *gorm.DB) error {
+			return UpdateIssuesAlreadyRead(ctx, []int{1}, true, tx)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct{ limit, want int }{{-1, 1}, {0, 1}, {2, 2}, {maxSelectActions + 1, 3}} {
+		actions, err := SelectActions(ctx, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) != tt.want {
+			t.Errorf("SelectActions(%d) returned %d actions, want %d", tt.limit, len(actions), tt.want)
+		}
+	}
+	actions, err := SelectActions(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actions[0].ID != 3 || !reflect.DeepEqual(actions[0].IssueIDs, []int{1}) {
+		t.Errorf("got %+v, want the latest action of issue 1", actions[0])
+	}
+}
+
+func TestPruneActions(t *testing.T) {
+	ctx := context.Background()
+	openTestDB(t)
+	insertTestIssues(t, 1)
+
+	for i := 0; i < maxActionLogs; i++ {
+		err := RecordAction(ctx, ActionMarkAsRead, []int{1}, func(tx *gorm.DB) error {
+			return UpdateIssuesAlreadyRead(ctx, []int{1}, i%2 == 0, tx)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The undo action refers to an old action here, so it is pruned with the action.
+	if _, _, err := UndoActions(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, `update action_logs set undoneActionID = 2 where kind = ?`, ActionUndo)
+
+	err := RecordAction(ctx, ActionMarkAsRead, []int{1}, func(tx *gorm.DB) error {
+		return UpdateIssuesAlreadyRead(ctx, []int{1}, true, tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cnt, minID, entries int
+	if err := gormConn.Raw(`select count(*), min(id) from action_logs`).Row().Scan(&cnt, &minID); err != nil {
+		t.Fatal(err)
+	}
+	if err := gormConn.Raw(`select count(*) from action_log_entries where actionID < ?`, minID).Row().Scan(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != maxActionLogs-1 || minID != 3 || entries != 0 {
+		t.Errorf("got %d actions from %d and %d pruned entries, want %d actions from 3", cnt, minID, entries, maxActionLogs-1)
+	}
+}
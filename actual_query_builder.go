@@ -17,9 +17,9 @@ import (
 const GitHubURIlimit = 5000
 
 type ActualQuery struct {
-	query       string
-	conditions  []Condition
-	accessToken string
+	query      string
+	conditions []Condition
+	account    Account
 }
 
 type Condition struct {
@@ -27,34 +27,59 @@ type Condition struct {
 		Owner string
 		Name  string
 	}
-	channel Channel
+	channel    Channel
+	codeowners *CodeownersMatcher
 }
 
-func (c *Condition) satisfy(i github.Issue) bool {
+func (c *Condition) satisfy(ctx context.Context, client *github.Client, i github.Issue) (bool, error) {
 	owner, name := repoInfoFromIssue(i)
 	for _, r := range c.unlessRepository {
 		if owner == r.Owner && name == r.Name {
-			return false
+			return false, nil
 		}
 	}
-	return true
+	if c.codeowners != nil {
+		return c.codeowners.owns(ctx, client, i)
+	}
+	return true, nil
 }
 
 func BuildActualQuery(ctx context.Context, cs []Channel) ([]ActualQuery, error) {
 	log.Println("Start to build actual queries")
 	res := make([]ActualQuery, 0)
 	for _, c := range cs {
-		token := c.Account.AccessToken
+		if c.IsVirtual() || !c.Enabled {
+			continue
+		}
 		qs, err := c.Queries(ctx)
 		if err != nil {
 			return nil, err
 		}
 
+		cond := Condition{channel: c}
+		excluded, err := c.ExcludedRepositories()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range excluded {
+			owner, name, err := splitRepositoryName(r)
+			if err != nil {
+				return nil, err
+			}
+			cond.unlessRepository = append(cond.unlessRepository, struct {
+				Owner string
+				Name  string
+			}{Owner: owner, Name: name})
+		}
+		if c.System.Valid && c.System.String == "codeowners" {
+			cond.codeowners = NewCodeownersMatcher(c.ID)
+		}
+
 		for _, q := range qs {
 			aq := ActualQuery{
-				query:       q,
-				conditions:  []Condition{{channel: c}},
-				accessToken: token,
+				query:      q,
+				conditions: []Condition{cond},
+				account:    c.Account,
 			}
 			res = append(res, aq)
 		}
@@ -63,21 +88,30 @@ func BuildActualQuery(ctx context.Context, cs []Channel) ([]ActualQuery, error)
 	return res, nil
 }
 
-func buildSystemQueries(ctx context.Context, kind string, client *github.Client) ([]string, error) {
+// splitRepositoryName splits "owner/name".
+func splitRepositoryName(r string) (string, string, error) {
+	s := strings.Split(r, "/")
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", errors.Errorf("Repository must be owner/name: %q", r)
+	}
+	return s[0], s[1], nil
+}
+
+// systemChannelKinds are kinds of channels whose queries are built by buildSystemQueries.
+// The value is true if the kind requires raw queries.
+var systemChannelKinds = map[string]bool{
+	"teams":      false,
+	"watching":   false,
+	"codeowners": true,
+}
+
+func buildSystemQueries(ctx context.Context, c Channel, client *github.Client) ([]string, error) {
+	kind := c.System.String
 	switch kind {
 	case "teams":
-		var allTeams []*github.Team
-		opt := &github.ListOptions{PerPage: 100}
-		for {
-			teams, resp, err := client.Teams.ListUserTeams(ctx, opt)
-			if err != nil {
-				return nil, err
-			}
-			allTeams = append(allTeams, teams...)
-			if resp.NextPage == 0 {
-				break
-			}
-			opt.Page = resp.NextPage
+		allTeams, err := listUserTeams(ctx, client)
+		if err != nil {
+			return nil, err
 		}
 		var q []string
 		for _, t := range allTeams {
@@ -112,7 +146,39 @@ func buildSystemQueries(ctx context.Context, kind string, client *github.Client)
 			}
 		}
 		return res, nil
+	case "codeowners":
+		// Queries of codeowners channel specify repositories to watch, such as "repo:pocke/korat-go".
+		// Pull requests are filtered by CODEOWNERS after searching.
+		qs, err := c.rawQueries()
+		if err != nil {
+			return nil, err
+		}
+		if len(qs) == 0 {
+			return nil, errors.Errorf("codeowners channel %d does not have any queries.", c.ID)
+		}
+		res := make([]string, len(qs))
+		for idx, q := range qs {
+			res[idx] = q + " is:pr"
+		}
+		return res, nil
 	default:
 		return nil, errors.Errorf("%s is not a valid system type.", kind)
 	}
 }
+
+func listUserTeams(ctx context.Context, client *github.Client) ([]*github.Team, error) {
+	var allTeams []*github.Team
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := client.Teams.ListUserTeams(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		allTeams = append(allTeams, teams...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return allTeams, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Issues are distributed to these channels. One more channel has a few issues.
+const benchmarkChannels = 4
+
+// The default is the size of a large database. Channels of this size scan the sort index, see timeIndexMinIssues.
+// The inbox without indexes is quadratic, so a smaller size shortens the benchmark.
+var benchmarkIssues = flag.Int("bench-issues", 200000, "number of issues inserted by BenchmarkSelectIssues")
+
+type benchmarkCase struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// BenchmarkSelectIssues measures issue time queries with and without the indexes of migration 24.
+// The queries are the same in both, so only the indexes are compared.
+func BenchmarkSelectIssues(b *testing.B) {
+	openTestDB(b)
+	if err := seedBenchmarkIssues(*benchmarkIssues); err != nil {
+		b.Fatal(err)
+	}
+
+	var index Migration
+	for _, m := range migrations {
+		if m.ID == 24 {
+			index = m
+		}
+	}
+
+	ctx := context.Background()
+	for _, v := range []struct {
+		name string
+		sql  string
+	}{
+		{"without indexes", index.Down},
+		{"with indexes", index.Up},
+	} {
+		if err := gormConn.Exec(v.sql).Error; err != nil {
+			b.Fatal(err)
+		}
+		for _, c := range benchmarkCases() {
+			b.Run(v.name+"/"+c.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := c.run(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func benchmarkCases() []benchmarkCase {
+	channelQuery := func(channelID int, sort string) *SearchIssuesQuery {
+		return &SearchIssuesQuery{
+			perPage:    defaultPerPage,
+			sort:       sort,
+			order:      "desc",
+			channelIDs: []int{channelID},
+			filter:     &SearchIssueFilter{Open: true, Closed: true, Merged: true},
+		}
+	}
+	edge := func(order string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			return EdgeIssueTime(1, order).First(&Issue{}).Error
+		}
+	}
+
+	return []benchmarkCase{
+		{"channel updated", func(ctx context.Context) error {
+			_, err := SelectIssues(ctx, channelQuery(1, "updated"))
+			return err
+		}},
+		{"channel created", func(ctx context.Context) error {
+			_, err := SelectIssues(ctx, channelQuery(1, "created"))
+			return err
+		}},
+		{"channel 10 pages", func(ctx context.Context) error {
+			q := channelQuery(1, "updated")
+			for i := 0; i < 10; i++ {
+				issues, err := SelectIssues(ctx, q)
+				if err != nil || len(issues) == 0 {
+					return err
+				}
+				q.cursor = newIssuesCursor(issues[len(issues)-1], q.sort, q.order)
+			}
+			return nil
+		}},
+		{"small channel updated", func(ctx context.Context) error {
+			_, err := SelectIssues(ctx, channelQuery(benchmarkChannels+1, "updated"))
+			return err
+		}},
+		{"inbox", func(ctx context.Context) error {
+			_, err := SelectIssues(ctx, InboxQuery(&SearchIssueFilter{Open: true, Closed: true, Merged: true}))
+			return err
+		}},
+		{"newest issue of query", edge("desc")},
+		{"oldest issue of query", edge("asc")},
+	}
+}
+
+// seedBenchmarkIssues inserts n issues into channels of one account.
+// Each issue is in one channel, and every tenth issue is also in the next channel.
+// The last channel has every 2000th issue.
+func seedBenchmarkIssues(n int) error {
+	a := &Account{DisplayName: "bench", UrlBase: defaultUrlBase, ApiUrlBase: defaultApiUrlBase}
+	if err := gormConn.Create(a).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	for i := 1; i <= benchmarkChannels+1; i++ {
+		c, err := NewChannel(a.ID, fmt.Sprintf("channel %d", i), []string{fmt.Sprintf("query %d", i)}, "", nil)
+		if err != nil {
+			return err
+		}
+		c.Position = i
+		if err := gormConn.Create(c).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		if err := gormConn.Create(&Query{Query: fmt.Sprintf("query %d", i)}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	tx, err := gormConn.DB().Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := insertBenchmarkIssues(tx, n); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+func insertBenchmarkIssues(tx *sql.Tx, n int) error {
+	const users = 1000
+	for id := 1; id <= users; id++ {
+		_, err := tx.Exec(`insert into github_users (id, login, avatarURL) values (?, ?, '')`, id, fmt.Sprintf("user%d", id))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	issueStmt, err := tx.Prepare(`
+		insert into issues
+		(id, number, title, userID, repoOwner, repoName, state, locked, comments, createdAt, updatedAt, closedAt, isPullRequest, body, alreadyRead)
+		values (?, ?, ?, ?, 'owner', ?, ?, 0, ?, ?, ?, ?, ?, '', ?)
+	`)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer issueStmt.Close()
+	channelIssueStmt, err := tx.Prepare(`insert into channel_issues (issueID, channelID, queryID) values (?, ?, ?)`)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer channelIssueStmt.Close()
+
+	r := rand.New(rand.NewSource(1))
+	now := time.Now()
+	for id := 1; id <= n; id++ {
+		created := now.Add(-time.Duration(r.Int63n(int64(2 * 365 * 24 * time.Hour))))
+		updated := created.Add(time.Duration(r.Int63n(int64(now.Sub(created)) + 1)))
+		state := "open"
+		var closed sql.NullString
+		if r.Intn(2) == 0 {
+			state = "closed"
+			closed = sql.NullString{String: fmtTime(updated), Valid: true}
+		}
+		_, err := issueStmt.Exec(
+			id, id, fmt.Sprintf("Issue %d", id), r.Intn(users)+1, fmt.Sprintf("repo%d", id%50), state, r.Intn(20),
+			fmtTime(created), fmtTime(updated), closed, id%3 == 0, r.Intn(2) == 0,
+		)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		ch := id%benchmarkChannels + 1
+		if _, err := channelIssueStmt.Exec(id, ch, ch); err != nil {
+			return errors.WithStack(err)
+		}
+		if id%10 == 0 {
+			next := ch%benchmarkChannels + 1
+			if _, err := channelIssueStmt.Exec(id, next, next); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		if id%2000 == 0 {
+			if _, err := channelIssueStmt.Exec(id, benchmarkChannels+1, benchmarkChannels+1); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
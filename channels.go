@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// invalidChannelError is returned by CreateChannel and UpdateChannel if the channel is invalid.
+type invalidChannelError struct {
+	err error
+}
+
+func (e *invalidChannelError) Error() string {
+	return e.err.Error()
+}
+
+// validateChannel checks the channel is fetchable or composable.
+// Normal and codeowners channels require queries, and virtual channels require existing source channels.
+func validateChannel(ctx context.Context, c *Channel) error {
+	if c.DisplayName == "" {
+		return errors.New("DisplayName is required")
+	}
+	a := Account{}
+	res := gormConn.Where("id = ?", c.AccountID).First(&a)
+	if res.RecordNotFound() {
+		return errors.Errorf("Account %d does not exist", c.AccountID)
+	}
+	if res.Error != nil {
+		return errors.WithStack(res.Error)
+	}
+	if c.ReadMode == "" {
+		c.ReadMode = ReadModeGlobal
+	}
+	if c.ReadMode != ReadModeGlobal && c.ReadMode != ReadModeChannel {
+		return errors.Errorf("Unknown read mode: %s", c.ReadMode)
+	}
+
+	qs, err := c.rawQueries()
+	if err != nil {
+		return errors.Errorf("Queries must be a JSON array of strings: %s", c.QueriesRaw)
+	}
+	seen := make(map[string]bool, len(qs))
+	for _, q := range qs {
+		if q == "" {
+			return errors.New("Query must not be empty")
+		}
+		if seen[q] {
+			return errors.Errorf("Query is duplicated: %q", q)
+		}
+		seen[q] = true
+	}
+	if a.isApp() {
+		if err := checkAppChannel(c.System.String, qs); err != nil {
+			return err
+		}
+	}
+
+	if c.IsVirtual() {
+		if c.ReadMode == ReadModeChannel {
+			return errors.New("Virtual channels cannot have the channel read mode")
+		}
+		b, err := newChannelIssuesQueryBuilder(gormConn)
+		if err != nil {
+			return err
+		}
+		b.channels[c.ID] = *c
+		_, _, err = b.build(*c)
+		return err
+	}
+
+	if c.SourceChannelIDsRaw.Valid {
+		return errors.New("Only virtual channels can have source channels")
+	}
+	excluded, err := c.ExcludedRepositories()
+	if err != nil {
+		return errors.Errorf("Excluded repositories must be a JSON array of strings: %s", c.ExcludedRepositoriesRaw.String)
+	}
+	for _, r := range excluded {
+		if _, _, err := splitRepositoryName(r); err != nil {
+			return err
+		}
+	}
+	needQueries := true
+	if c.System.Valid {
+		var ok bool
+		needQueries, ok = systemChannelKinds[c.System.String]
+		if !ok {
+			return errors.Errorf("%s is not a valid system type.", c.System.String)
+		}
+	}
+	if needQueries && len(qs) == 0 {
+		return errors.New("Queries are required")
+	}
+	return nil
+}
+
+// NewChannel builds a channel. system is empty for normal channels.
+func NewChannel(accountID int, displayName string, queries []string, system string, sourceChannelIDs []int) (*Channel, error) {
+	if queries == nil {
+		queries = []string{}
+	}
+	qs, err := json.Marshal(queries)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c := &Channel{
+		DisplayName: displayName,
+		QueriesRaw:  string(qs),
+		AccountID:   accountID,
+		ReadMode:    ReadModeGlobal,
+		Enabled:     true,
+	}
+	if system != "" {
+		c.System = sql.NullString{String: system, Valid: true}
+	}
+	if len(sourceChannelIDs) != 0 {
+		ids, err := json.Marshal(sourceChannelIDs)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		c.SourceChannelIDsRaw = sql.NullString{String: string(ids), Valid: true}
+	}
+	return c, nil
+}
+
+// CreateChannel creates the channel. It is placed at the end of the account if Position is 0.
+func CreateChannel(ctx context.Context, c *Channel) error {
+	if err := validateChannel(ctx, c); err != nil {
+		return &invalidChannelError{err: err}
+	}
+	return txGorm(func(tx *gorm.DB) error {
+		if c.Position == 0 {
+			err := tx.Raw(`select coalesce(max(position), 0) + 1 from channels where accountID = ?`, c.AccountID).Row().Scan(&c.Position)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return errors.WithStack(tx.Create(c).Error)
+	})
+}
+
+// UpdateChannel saves the channel.
+// Issues found only by removed queries are removed from the channel.
+// Managed channels are updated only by the config file.
+func UpdateChannel(ctx context.Context, c *Channel) error {
+	if err := validateChannel(ctx, c); err != nil {
+		return &invalidChannelError{err: err}
+	}
+	return txGorm(func(tx *gorm.DB) error {
+		if err := checkUnmanaged(tx, "channels", c.ID); err != nil {
+			return err
+		}
+		if err := tx.Save(c).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return deleteStaleChannelIssues(tx, c)
+	})
+}
+
+// deleteStaleChannelIssues removes issues found only by queries which the channel no longer has.
+func deleteStaleChannelIssues(tx *gorm.DB, c *Channel) error {
+	// Queries of system and virtual channels are not stored in channel_issues as they are.
+	if c.System.Valid {
+		return nil
+	}
+	qs, err := c.rawQueries()
+	if err != nil {
+		return err
+	}
+	// An empty string avoids an empty list, which is NULL in gorm.
+	err = tx.Exec(`
+		delete from channel_issues
+		where channelID = ? AND queryID NOT IN (select id from queries where query IN (?))
+	`, c.ID, append(qs, "")).Error
+	return errors.WithStack(err)
+}
+
+// ReorderChannels sets positions of the channels in the given order.
+// channelIDs must be all channels of one account.
+func ReorderChannels(ctx context.Context, channelIDs []int) error {
+	if len(channelIDs) == 0 {
+		return errors.New("ChannelIDs are required")
+	}
+	return txGorm(func(tx *gorm.DB) error {
+		var accountIDs []int
+		err := tx.Table("channels").Where("id = ?", channelIDs[0]).Pluck("accountID", &accountIDs).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(accountIDs) == 0 {
+			return errors.Errorf("Channel %d does not exist", channelIDs[0])
+		}
+		var ids []int
+		if err := tx.Table("channels").Where("accountID = ?", accountIDs[0]).Pluck("id", &ids).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		seen := make(map[int]bool, len(channelIDs))
+		for _, id := range channelIDs {
+			if idxIntSlice(ids, id) == -1 {
+				return errors.Errorf("Channel %d is not a channel of account %d", id, accountIDs[0])
+			}
+			if seen[id] {
+				return errors.Errorf("Channel %d is duplicated", id)
+			}
+			seen[id] = true
+		}
+		if len(channelIDs) != len(ids) {
+			return errors.Errorf("ChannelIDs must contain all %d channels of account %d", len(ids), accountIDs[0])
+		}
+
+		for idx, id := range channelIDs {
+			res := tx.Exec(`update channels set position = ? where id = ?`, idx+1, id)
+			if res.Error != nil {
+				return errors.WithStack(res.Error)
+			}
+			if res.RowsAffected == 0 {
+				return errors.Errorf("Channel %d does not exist", id)
+			}
+		}
+		return nil
+	})
+}
+
+// ChannelParams is the request body to create or update a channel.
+// ReadMode is updated by UpdateChannelReadMode.
+type ChannelParams struct {
+	AccountID            int
+	DisplayName          string
+	Queries              []string
+	System               string
+	SourceChannelIDs     []int
+	ExcludedRepositories []string
+	Position             int
+	Enabled              bool
+}
+
+// channelParams returns the current values of the channel, so that a request can change a part of them.
+func (c Channel) channelParams() (ChannelParams, error) {
+	p := ChannelParams{
+		AccountID:   c.AccountID,
+		DisplayName: c.DisplayName,
+		System:      c.System.String,
+		Position:    c.Position,
+		Enabled:     c.Enabled,
+	}
+	var err error
+	if p.Queries, err = c.rawQueries(); err != nil {
+		return p, err
+	}
+	if p.ExcludedRepositories, err = c.ExcludedRepositories(); err != nil {
+		return p, err
+	}
+	if c.SourceChannelIDsRaw.Valid {
+		if err := json.Unmarshal([]byte(c.SourceChannelIDsRaw.String), &p.SourceChannelIDs); err != nil {
+			return p, errors.WithStack(err)
+		}
+	}
+	return p, nil
+}
+
+func (p ChannelParams) apply(c *Channel) error {
+	n, err := NewChannel(p.AccountID, p.DisplayName, p.Queries, p.System, p.SourceChannelIDs)
+	if err != nil {
+		return err
+	}
+	c.AccountID = n.AccountID
+	c.DisplayName = n.DisplayName
+	c.QueriesRaw = n.QueriesRaw
+	c.System = n.System
+	c.SourceChannelIDsRaw = n.SourceChannelIDsRaw
+	c.ExcludedRepositoriesRaw = sql.NullString{}
+	if len(p.ExcludedRepositories) != 0 {
+		ex, err := json.Marshal(p.ExcludedRepositories)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		c.ExcludedRepositoriesRaw = sql.NullString{String: string(ex), Valid: true}
+	}
+	c.Position = p.Position
+	c.Enabled = p.Enabled
+	return nil
+}
+
+// checkChannelsDeletable returns an error if a virtual channel not in channelIDs is composed from any of them.
+func checkChannelsDeletable(b *channelIssuesQueryBuilder, channelIDs []int) error {
+	for _, c := range b.channels {
+		if idxIntSlice(channelIDs, c.ID) != -1 {
+			continue
+		}
+		ok, err := b.dependsOn(c, channelIDs)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return errors.Errorf("Virtual channel %d (%s) is composed from the deleted channels", c.ID, c.DisplayName)
+		}
+	}
+	return nil
+}
+
+// deleteChannels deletes the channels in the transaction.
+// Their issues are deleted by the foreign key.
+func deleteChannels(tx *gorm.DB, channelIDs []int) error {
+	if err := removeChannelsFromViews(tx, channelIDs); err != nil {
+		return err
+	}
+	for _, chunk := range chunkInts(channelIDs) {
+		if err := tx.Exec(`delete from channels where id IN (?)`, chunk).Error; err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// DeleteChannel deletes the channel.
+// It fails if a virtual channel is composed from the channel, or the channel is managed.
+func DeleteChannel(ctx context.Context, channelID int) error {
+	return txGorm(func(tx *gorm.DB) error {
+		if err := checkUnmanaged(tx, "channels", channelID); err != nil {
+			return err
+		}
+		b, err := newChannelIssuesQueryBuilder(tx)
+		if err != nil {
+			return err
+		}
+		if _, ok := b.channels[channelID]; !ok {
+			return errors.Errorf("Channel %d does not exist", channelID)
+		}
+		if err := checkChannelsDeletable(b, []int{channelID}); err != nil {
+			return err
+		}
+		return deleteChannels(tx, []int{channelID})
+	})
+}
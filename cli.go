@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+const usage = `Usage: korat-go [--profile NAME] [--db PATH] [--port PORT] [--config PATH] COMMAND [ARGS]
+
+Commands:
+  serve                   Start workers and the HTTP server (default)
+  migrate [--dry-run]     Apply pending migrations. The database is backed up before applying them
+  migrate status          Show the status of migrations
+  migrate down [--dry-run] [N]
+                          Revert the last N migrations (default 1) if they are reversible
+  fetch-once              Fetch new issues of each query one time and exit
+  account list
+  account add --name NAME (--token TOKEN | --token-env NAME | --token-command COMMAND) [--url-base URL] [--api-url-base URL]
+  account add --name NAME --app-id ID --app-installation-id ID --app-private-key-file PATH
+  account remove ID
+  channel list
+  channel add --account ID --name NAME [--query QUERY]... [--system KIND] [--sources ID,ID...]
+  channel remove ID
+  rekey (--new-key-file PATH | --new-passphrase-env NAME)
+                          Encrypt access tokens with a new key
+`
+
+// usageError is an error caused by the user input. It is printed without the stack trace.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func runCommand(conf *Config, args []string) error {
+	cmd := "serve"
+	if len(args) != 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	if cmd == "help" {
+		fmt.Print(usage)
+		return nil
+	}
+	if cmd == "migrate" {
+		return runMigrate(conf, args)
+	}
+
+	switch cmd {
+	case "serve", "fetch-once", "account", "channel", "rekey":
+	default:
+		return newUsageError("Unknown command: %s\n\n%s", cmd, usage)
+	}
+
+	if err := openDB(conf.DBPath); err != nil {
+		return err
+	}
+	if err := dbMigrate(); err != nil {
+		return err
+	}
+	if err := initTokenCipher(conf.TokenKey); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if cmd == "rekey" {
+		return runRekey(conf, args)
+	}
+	switch cmd {
+	case "serve":
+		if err := reconcileConfigFile(ctx, conf); err != nil {
+			return err
+		}
+		return runServe(ctx, conf)
+	case "fetch-once":
+		if err := reconcileConfigFile(ctx, conf); err != nil {
+			return err
+		}
+		return runFetchOnce(ctx)
+	case "account":
+		return runAccount(ctx, args)
+	case "channel":
+		return runChannel(ctx, args)
+	}
+	panic("unreachable")
+}
+
+func runServe(ctx context.Context, conf *Config) error {
+	if err := StartServerHeartbeat(ctx); err != nil {
+		return err
+	}
+	initAccountWorkers(ctx)
+	go func() {
+		err := StartFetchIssues(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}()
+	go func() {
+		err := StartDetermineMerged(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}()
+	go func() {
+		err := StartWakeSnoozedIssues(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}()
+	if conf.ConfigPath != "" {
+		go func() {
+			err := StartWatchFileConfig(ctx, conf.ConfigPath)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+	go StartHTTPServer(conf.Port)
+	select {}
+}
+
+func reconcileConfigFile(ctx context.Context, conf *Config) error {
+	if conf.ConfigPath == "" {
+		return nil
+	}
+	c, err := LoadFileConfig(conf.ConfigPath)
+	if err != nil {
+		return err
+	}
+	return ReconcileFileConfig(ctx, c)
+}
+
+func runMigrate(conf *Config, args []string) error {
+	sub := "up"
+	if len(args) != 0 && (args[0] == "status" || args[0] == "down") {
+		sub = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	dryRun := fs.Bool("dry-run", false, "show migrations without applying them")
+	if err := fs.Parse(args); err != nil {
+		return newUsageError("%s", err)
+	}
+
+	if err := openDB(conf.DBPath); err != nil {
+		return err
+	}
+	fmt.Printf("Database: %s\n", conf.DBPath)
+
+	switch sub {
+	case "status":
+		statuses, err := MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSTATUS\tAPPLIED AT\tREVERSIBLE")
+		for _, s := range statuses {
+			status := "pending"
+			switch {
+			case s.Name == "":
+				status = "unknown"
+			case s.Changed:
+				status = "changed"
+			case s.Applied:
+				status = "applied"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\n", s.ID, s.Name, status, s.AppliedAt, s.Reversible())
+		}
+		return w.Flush()
+	case "down":
+		n := 1
+		if fs.NArg() != 0 {
+			var err error
+			n, err = strconv.Atoi(fs.Arg(0))
+			if err != nil || n < 1 {
+				return newUsageError("Invalid number of migrations: %q", fs.Arg(0))
+			}
+		}
+		ms, backup, err := MigrateDown(n, *dryRun)
+		printBackup(backup)
+		if err != nil {
+			return newUsageError("%s", err)
+		}
+		printMigrations("revert", "Reverted", ms, *dryRun, func(m Migration) string { return m.Down })
+		return nil
+	default:
+		ms, backup, err := MigrateUp(*dryRun)
+		printBackup(backup)
+		if err != nil {
+			return err
+		}
+		printMigrations("apply", "Applied", ms, *dryRun, func(m Migration) string { return m.Up })
+		return nil
+	}
+}
+
+func printBackup(path string) {
+	if path != "" {
+		fmt.Printf("Backed up the database to %s\n", path)
+	}
+}
+
+// printMigrations prints migrations with SQL on dry run.
+func printMigrations(verb, done string, ms []Migration, dryRun bool, sql func(Migration) string) {
+	if len(ms) == 0 {
+		fmt.Printf("No migrations to %s\n", verb)
+		return
+	}
+	for _, m := range ms {
+		if !dryRun {
+			fmt.Printf("%s migration %d (%s)\n", done, m.ID, m.Name)
+			continue
+		}
+		fmt.Printf("-- %s migration %d (%s)\n", verb, m.ID, m.Name)
+		fmt.Println(strings.TrimSpace(sql(m)))
+	}
+}
+
+func runRekey(conf *Config, args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	keyFile := fs.String("new-key-file", "", "new key file, which is generated if it does not exist")
+	passphraseEnv := fs.String("new-passphrase-env", "", "environment variable name of the new passphrase")
+	if err := fs.Parse(args); err != nil {
+		return newUsageError("%s", err)
+	}
+	if (*keyFile == "") == (*passphraseEnv == "") {
+		return newUsageError("Either --new-key-file or --new-passphrase-env is required")
+	}
+
+	newConf := &TokenKeyConfig{KeyFile: *keyFile}
+	if *passphraseEnv != "" {
+		newConf.Passphrase = os.Getenv(*passphraseEnv)
+		if newConf.Passphrase == "" {
+			return newUsageError("Environment variable %s is empty", *passphraseEnv)
+		}
+	}
+	if err := RekeyTokens(newConf); err != nil {
+		return err
+	}
+
+	if *keyFile != "" {
+		fmt.Printf("Access tokens are encrypted with %s. Use --key-file or KORAT_KEY_FILE from now on.\n", *keyFile)
+	} else {
+		fmt.Println("Access tokens are encrypted with the new passphrase. Set it to KORAT_PASSPHRASE from now on.")
+	}
+	return nil
+}
+
+func runFetchOnce(ctx context.Context) error {
+	failed, err := FetchNewIssuesOnce(ctx)
+	if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return errors.Errorf("%d queries failed", failed)
+	}
+	return nil
+}
+
+func runAccount(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("account requires a subcommand: list, add or remove")
+	}
+	switch args[0] {
+	case "list":
+		accounts := make([]Account, 0)
+		if err := gormConn.Order("id").Find(&accounts).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tURL\tAPI URL\tTOKEN")
+		for _, a := range accounts {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", a.ID, a.DisplayName, a.UrlBase, a.ApiUrlBase, a.authSource())
+		}
+		return w.Flush()
+	case "add":
+		fs := flag.NewFlagSet("account add", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		name := fs.String("name", "", "display name")
+		token := fs.String("token", "", "GitHub access token, which is stored in the database")
+		tokenEnv := fs.String("token-env", "", "environment variable name of the access token")
+		tokenCommand := fs.String("token-command", "", "command printing the access token, such as \"gh auth token\"")
+		urlBase := fs.String("url-base", defaultUrlBase, "base URL of GitHub")
+		apiUrlBase := fs.String("api-url-base", defaultApiUrlBase, "base URL of GitHub API")
+		appID := fs.Int64("app-id", 0, "GitHub App ID")
+		appInstallationID := fs.Int64("app-installation-id", 0, "installation ID of the GitHub App")
+		appPrivateKeyFile := fs.String("app-private-key-file", "", "path to the private key of the GitHub App")
+		if err := fs.Parse(args[1:]); err != nil {
+			return newUsageError("%s", err)
+		}
+		a := &Account{DisplayName: *name, UrlBase: *urlBase, ApiUrlBase: *apiUrlBase}
+		err := a.setTokenRef(TokenRef{Literal: *token, Env: *tokenEnv, Command: strings.Fields(*tokenCommand)})
+		if err != nil {
+			return err
+		}
+		if *appID != 0 || *appInstallationID != 0 || *appPrivateKeyFile != "" {
+			if *token != "" || *tokenEnv != "" || *tokenCommand != "" {
+				return newUsageError("Token flags and GitHub App flags cannot be used together")
+			}
+			conf := AppConfig{ID: *appID, InstallationID: *appInstallationID, PrivateKeyFile: *appPrivateKeyFile}
+			if _, err := conf.privateKey(); err != nil {
+				return newUsageError("%s", err)
+			}
+			a.setAppConfig(conf)
+		}
+		if err := validateAccount(ctx, a); err != nil {
+			return newUsageError("%s", err)
+		}
+		if err := CreateAccount(ctx, a); err != nil {
+			return err
+		}
+		fmt.Printf("Created account %d\n", a.ID)
+		return nil
+	case "remove":
+		id, err := parseIDArg(args[1:])
+		if err != nil {
+			return err
+		}
+		if err := DeleteAccount(ctx, id); err != nil {
+			return newUsageError("%s", err)
+		}
+		fmt.Printf("Removed account %d\n", id)
+		return nil
+	default:
+		return newUsageError("Unknown account subcommand: %s", args[0])
+	}
+}
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runChannel(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("channel requires a subcommand: list, add or remove")
+	}
+	switch args[0] {
+	case "list":
+		chs := make([]Channel, 0)
+		if err := gormConn.Order("id").Find(&chs).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tACCOUNT\tNAME\tSYSTEM\tQUERIES\tSOURCES")
+		for _, c := range chs {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", c.ID, c.AccountID, c.DisplayName, c.System.String, c.QueriesRaw, c.SourceChannelIDsRaw.String)
+		}
+		return w.Flush()
+	case "add":
+		fs := flag.NewFlagSet("channel add", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		accountID := fs.Int("account", 0, "account ID")
+		name := fs.String("name", "", "display name")
+		var queries stringsFlag
+		fs.Var(&queries, "query", "GitHub search query (repeatable)")
+		system := fs.String("system", "", "teams, watching, codeowners, union, intersection or difference")
+		sources := fs.String("sources", "", "comma separated source channel IDs of virtual channels")
+		if err := fs.Parse(args[1:]); err != nil {
+			return newUsageError("%s", err)
+		}
+
+		var sourceIDs []int
+		if *sources != "" {
+			for _, s := range strings.Split(*sources, ",") {
+				id, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil {
+					return newUsageError("Invalid channel ID: %q", s)
+				}
+				sourceIDs = append(sourceIDs, id)
+			}
+		}
+		c, err := NewChannel(*accountID, *name, queries, *system, sourceIDs)
+		if err != nil {
+			return err
+		}
+		if err := CreateChannel(ctx, c); err != nil {
+			if _, ok := err.(*invalidChannelError); ok {
+				return newUsageError("%s", err)
+			}
+			return err
+		}
+		fmt.Printf("Created channel %d\n", c.ID)
+		return nil
+	case "remove":
+		id, err := parseIDArg(args[1:])
+		if err != nil {
+			return err
+		}
+		if err := DeleteChannel(ctx, id); err != nil {
+			return newUsageError("%s", err)
+		}
+		fmt.Printf("Removed channel %d\n", id)
+		return nil
+	default:
+		return newUsageError("Unknown channel subcommand: %s", args[0])
+	}
+}
+
+func parseIDArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, newUsageError("An ID is required")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, newUsageError("Invalid ID: %q", args[0])
+	}
+	return id, nil
+}
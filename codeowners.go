@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v21/github"
+	"github.com/pkg/errors"
+)
+
+// GitHub looks up CODEOWNERS from these paths in this order.
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// CODEOWNERS and team memberships are not changed frequently,
+// so korat caches them for a while to save API calls.
+const codeownersCacheDuration = 1 * time.Hour
+
+type codeownersRule struct {
+	pattern *regexp.Regexp
+	owners  []string
+}
+
+type codeownersFile struct {
+	rules     []codeownersRule
+	fetchedAt time.Time
+}
+
+// CodeownersMatcher determines whether a pull request touches paths
+// owned by the account's user or the user's teams.
+type CodeownersMatcher struct {
+	channelID int
+
+	mu          sync.Mutex
+	me          map[string]bool
+	meFetchedAt time.Time
+	files       map[string]*codeownersFile
+}
+
+func NewCodeownersMatcher(channelID int) *CodeownersMatcher {
+	return &CodeownersMatcher{
+		channelID: channelID,
+		files:     make(map[string]*codeownersFile),
+	}
+}
+
+func (m *CodeownersMatcher) owns(ctx context.Context, client *github.Client, i github.Issue) (bool, error) {
+	if !i.IsPullRequest() {
+		return false, nil
+	}
+	// Closed pull requests are not checked, but they are kept updated if they are already in the channel.
+	if i.GetState() != "open" {
+		return ExistChannelIssue(ctx, m.channelID, int(i.GetID()))
+	}
+
+	me, err := m.owners(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	// A missing permission or a broken CODEOWNERS skips only this pull request, not the whole query.
+	owner, name := repoInfoFromIssue(i)
+	rules, err := m.rules(ctx, client, owner, name)
+	if err != nil {
+		log.Printf("Skip %s/%s#%d: %+v\n", owner, name, i.GetNumber(), err)
+		return false, nil
+	}
+	if len(rules) == 0 {
+		return false, nil
+	}
+
+	paths, err := fetchChangedFiles(ctx, client, i)
+	if err != nil {
+		log.Printf("Skip %s/%s#%d: %+v\n", owner, name, i.GetNumber(), err)
+		return false, nil
+	}
+	for _, path := range paths {
+		for _, o := range ownersForPath(rules, path) {
+			if me[strings.ToLower(o)] {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// owners returns "@login" and "@org/team" for the account.
+func (m *CodeownersMatcher) owners(ctx context.Context, client *github.Client) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.me != nil && time.Since(m.meFetchedAt) < codeownersCacheDuration {
+		return m.me, nil
+	}
+
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	teams, err := listUserTeams(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	me := map[string]bool{"@" + strings.ToLower(user.GetLogin()): true}
+	for _, t := range teams {
+		me[strings.ToLower("@"+t.Organization.GetLogin()+"/"+t.GetSlug())] = true
+	}
+	m.me = me
+	m.meFetchedAt = time.Now()
+	return me, nil
+}
+
+func (m *CodeownersMatcher) rules(ctx context.Context, client *github.Client, owner, name string) ([]codeownersRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := owner + "/" + name
+	if f, ok := m.files[key]; ok && time.Since(f.fetchedAt) < codeownersCacheDuration {
+		return f.rules, nil
+	}
+
+	content, err := fetchCodeowners(ctx, client, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseCodeowners(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "CODEOWNERS of %s is invalid", key)
+	}
+	m.files[key] = &codeownersFile{rules: rules, fetchedAt: time.Now()}
+	return rules, nil
+}
+
+// fetchCodeowners returns an empty string if the repository does not have CODEOWNERS.
+func fetchCodeowners(ctx context.Context, client *github.Client, owner, name string) (string, error) {
+	for _, path := range codeownersPaths {
+		file, _, resp, err := client.Repositories.GetContents(ctx, owner, name, path, nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if file == nil {
+			continue
+		}
+		return file.GetContent()
+	}
+	return "", nil
+}
+
+func parseCodeowners(content string) ([]codeownersRule, error) {
+	var res []codeownersRule
+	for _, line := range strings.Split(content, "\n") {
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		re, err := compileCodeownersPattern(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, codeownersRule{pattern: re, owners: fields[1:]})
+	}
+	return res, nil
+}
+
+// compileCodeownersPattern converts a gitignore style pattern to a regexp.
+// As GitHub does, "docs/*" matches files directly in docs but not docs/a/b.md.
+func compileCodeownersPattern(pattern string) (*regexp.Regexp, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	p := strings.TrimSuffix(pattern, "/")
+	anchored := strings.HasPrefix(p, "/") || strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	last := p[strings.LastIndex(p, "/")+1:]
+
+	re := ""
+	if anchored {
+		re += "^"
+	} else {
+		re += "^(?:.*/)?"
+	}
+	for i := 0; i < len(p); i++ {
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			re += "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			re += ".*"
+			i++
+		case p[i] == '*':
+			re += "[^/]*"
+		case p[i] == '?':
+			re += "[^/]"
+		default:
+			re += regexp.QuoteMeta(p[i : i+1])
+		}
+	}
+	// A pattern matching a directory owns everything under the directory.
+	// The last segment without wildcards may name either a file or a directory.
+	switch {
+	case dirOnly:
+		re += "/.*$"
+	case !strings.ContainsAny(last, "*?"):
+		re += "(?:/.*)?$"
+	default:
+		re += "$"
+	}
+
+	return regexp.Compile(re)
+}
+
+// ownersForPath returns owners of the last matching rule, as GitHub does.
+func ownersForPath(rules []codeownersRule, path string) []string {
+	for idx := len(rules) - 1; idx >= 0; idx-- {
+		if rules[idx].pattern.MatchString(path) {
+			return rules[idx].owners
+		}
+	}
+	return nil
+}
+
+// fetchChangedFiles returns paths changed by the pull request.
+// The paths are stored in the database until the pull request is updated.
+func fetchChangedFiles(ctx context.Context, client *github.Client, i github.Issue) ([]string, error) {
+	issueID := int(i.GetID())
+	updatedAt := fmtTime(i.GetUpdatedAt())
+	paths, ok, err := SelectChangedFiles(ctx, issueID, updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return paths, nil
+	}
+
+	owner, name := repoInfoFromIssue(i)
+	paths = make([]string, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, name, i.GetNumber(), opt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, f := range files {
+			paths = append(paths, f.GetFilename())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	if err := ImportChangedFiles(ctx, issueID, updatedAt, paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestCompileCodeownersPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		// Unanchored patterns match at any depth.
+		{"*.js", "app.js", true},
+		{"*.js", "src/lib/app.js", true},
+		{"*.js", "app.jsx", false},
+		{"apps", "apps", true},
+		{"apps", "src/apps/main.go", true},
+		{"apps", "myapps/main.go", false},
+
+		// "*" does not match "/".
+		{"docs/*", "docs/a.md", true},
+		{"docs/*", "docs/a/b.md", false},
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/a/b.md", false},
+		{"src/?.go", "src/a.go", true},
+		{"src/?.go", "src/ab.go", false},
+
+		// "**" matches any number of directories.
+		{"**/logs", "logs/a.log", true},
+		{"**/logs", "build/logs/a.log", true},
+		{"**/logs", "deeply/nested/logs/a.log", true},
+		{"docs/**", "docs/a/b.md", true},
+		{"docs/**", "docs", false},
+		{"a/**/b.md", "a/b.md", true},
+		{"a/**/b.md", "a/x/y/b.md", true},
+
+		// A leading "/" anchors the pattern to the root.
+		{"/docs", "docs/a.md", true},
+		{"/docs", "src/docs/a.md", false},
+		{"/build/logs", "build/logs/a.log", true},
+		{"/build/logs", "x/build/logs/a.log", false},
+
+		// A pattern with "/" in the middle is anchored too.
+		{"build/logs", "build/logs/a.log", true},
+		{"build/logs", "x/build/logs/a.log", false},
+
+		// A trailing "/" matches only directories, at any depth.
+		{"apps/", "apps/main.go", true},
+		{"apps/", "src/apps/a/main.go", true},
+		{"apps/", "apps", false},
+		{"/docs/", "docs/a/b.md", true},
+		{"/docs/", "src/docs/a.md", false},
+
+		// Special characters of regexps are literal.
+		{"a+b.txt", "a+b.txt", true},
+		{"a+b.txt", "aab.txt", false},
+	}
+
+	for _, tt := range tests {
+		re, err := compileCodeownersPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compileCodeownersPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.path); got != tt.match {
+			t.Errorf("%q matches %q: got %v, want %v", tt.pattern, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestOwnersForPath(t *testing.T) {
+	rules, err := parseCodeowners(`
+# comment
+*       @global
+*.js    @js
+/docs/  @docs @org/writers
+docs/internal/*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		owners []string
+	}{
+		{"main.go", []string{"@global"}},
+		{"src/app.js", []string{"@js"}},
+		{"docs/app.js", []string{"@docs", "@org/writers"}},
+		// The last matching rule wins even if it has no owners.
+		{"docs/internal/a.md", nil},
+		{"docs/internal/a/b.md", []string{"@docs", "@org/writers"}},
+	}
+	for _, tt := range tests {
+		got := ownersForPath(rules, tt.path)
+		if len(got) != len(tt.owners) {
+			t.Errorf("ownersForPath(%q) = %v, want %v", tt.path, got, tt.owners)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.owners[i] {
+				t.Errorf("ownersForPath(%q) = %v, want %v", tt.path, got, tt.owners)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPort    = 5427
+	defaultProfile = "development"
+)
+
+// Config is a runtime configuration.
+// Each value is taken from a command-line flag, an environment variable or the default, in this order.
+type Config struct {
+	// Profile separates databases of instances on the same machine, such as "work" and "personal".
+	Profile string
+	DBPath  string
+	Port    int
+	// Path to the FileConfig. It is empty if there is no config file.
+	ConfigPath string
+	// Key to encrypt access tokens in the database
+	TokenKey *TokenKeyConfig
+}
+
+var profileNameRe = regexp.MustCompile(`\A[a-zA-Z0-9_-]+\z`)
+
+// parseConfig returns the configuration and the rest of args, which are the command and its arguments.
+func parseConfig(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("korat-go", flag.ContinueOnError)
+	profile := fs.String("profile", "", "profile name (env: KORAT_PROFILE, default: "+defaultProfile+")")
+	dbPath := fs.String("db", "", "path to the SQLite database (env: KORAT_DB, default: $XDG_CACHE_HOME/korat/PROFILE.sqlite3)")
+	port := fs.Int("port", 0, fmt.Sprintf("port to listen (env: KORAT_PORT, default: %d)", defaultPort))
+	keyFile := fs.String("key-file", "", "key file to encrypt access tokens (env: KORAT_KEY_FILE, default: $XDG_CONFIG_HOME/korat/PROFILE.key). KORAT_PASSPHRASE is used instead if it is set")
+	configPath := fs.String("config", "", "path to the YAML or TOML config file (env: KORAT_CONFIG, default: $XDG_CONFIG_HOME/korat/PROFILE.{yml,yaml,toml})")
+	// Errors are reported by the caller
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	c := &Config{
+		Profile: firstNonEmpty(*profile, os.Getenv("KORAT_PROFILE"), defaultProfile),
+		DBPath:  firstNonEmpty(*dbPath, os.Getenv("KORAT_DB")),
+		Port:    *port,
+
+		ConfigPath: firstNonEmpty(*configPath, os.Getenv("KORAT_CONFIG")),
+		TokenKey: &TokenKeyConfig{
+			KeyFile:    firstNonEmpty(*keyFile, os.Getenv("KORAT_KEY_FILE")),
+			Passphrase: os.Getenv("KORAT_PASSPHRASE"),
+		},
+	}
+	if !profileNameRe.MatchString(c.Profile) {
+		return nil, nil, errors.Errorf("Invalid profile name: %q", c.Profile)
+	}
+
+	if c.Port == 0 {
+		if p := os.Getenv("KORAT_PORT"); p != "" {
+			var err error
+			c.Port, err = strconv.Atoi(p)
+			if err != nil {
+				return nil, nil, errors.Errorf("KORAT_PORT must be an integer: %q", p)
+			}
+		} else {
+			c.Port = defaultPort
+		}
+	}
+
+	if c.DBPath == "" {
+		dir, err := cacheDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.DBPath = filepath.Join(dir, c.Profile+".sqlite3")
+	} else {
+		var err error
+		c.DBPath, err = homedir.Expand(c.DBPath)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	if c.TokenKey.KeyFile == "" {
+		dir, err := configDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.TokenKey.KeyFile = filepath.Join(dir, c.Profile+".key")
+	} else {
+		var err error
+		c.TokenKey.KeyFile, err = homedir.Expand(c.TokenKey.KeyFile)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	if c.ConfigPath == "" {
+		var err error
+		c.ConfigPath, err = defaultConfigPath(c.Profile)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var err error
+		c.ConfigPath, err = homedir.Expand(c.ConfigPath)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	return c, fs.Args(), nil
+}
+
+// configDir returns the config directory for korat following the XDG Base Directory Specification.
+func configDir() (string, error) {
+	if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+		return filepath.Join(d, "korat"), nil
+	}
+	d, err := homedir.Expand("~/.config/korat")
+	return d, errors.WithStack(err)
+}
+
+// cacheDir returns the directory for korat following the XDG Base Directory Specification.
+func cacheDir() (string, error) {
+	if d := os.Getenv("XDG_CACHE_HOME"); d != "" {
+		return filepath.Join(d, "korat"), nil
+	}
+	d, err := homedir.Expand("~/.cache/korat")
+	return d, errors.WithStack(err)
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FileConfig is a declarative definition of accounts and channels.
+// korat-go reconciles the accounts and channels tables with it.
+//
+// Accounts and channels created from the file are marked as managed.
+// A managed row is deleted when it is removed from the file,
+// but rows created by other ways are never changed by the reconciliation,
+// and the file cannot have an account or a channel with the same name as them.
+// Accounts are identified by the name, and channels by the account and the name.
+type FileConfig struct {
+	Accounts []AccountConfig `yaml:"accounts" toml:"accounts"`
+}
+
+// managedError is returned if a managed account or channel is updated or deleted by other ways than the file.
+type managedError struct {
+	kind string
+	id   int
+}
+
+func (e *managedError) Error() string {
+	return fmt.Sprintf("%s %d is managed by the config file", e.kind, e.id)
+}
+
+// checkUnmanaged returns managedError if the row is managed.
+// table is "accounts" or "channels".
+func checkUnmanaged(tx *gorm.DB, table string, id int) error {
+	var managed []bool
+	if err := tx.Table(table).Where("id = ?", id).Pluck("managed", &managed).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	if len(managed) == 0 || !managed[0] {
+		return nil
+	}
+	kind := "Account"
+	if table == "channels" {
+		kind = "Channel"
+	}
+	return &managedError{kind: kind, id: id}
+}
+
+type AccountConfig struct {
+	Name       string   `yaml:"name" toml:"name"`
+	UrlBase    string   `yaml:"urlBase" toml:"urlBase"`
+	ApiUrlBase string   `yaml:"apiUrlBase" toml:"apiUrlBase"`
+	Token      TokenRef `yaml:"token" toml:"token"`
+	// App is used instead of Token if it is set
+	App *AppConfig `yaml:"app" toml:"app"`
+
+	Channels []ChannelConfig `yaml:"channels" toml:"channels"`
+}
+
+type ChannelConfig struct {
+	Name    string   `yaml:"name" toml:"name"`
+	Queries []string `yaml:"queries" toml:"queries"`
+	// A system kind or a virtual channel operator
+	System string `yaml:"system" toml:"system"`
+	// Names of source channels in the same account for virtual channels
+	Sources []string `yaml:"sources" toml:"sources"`
+	// Repositories to exclude, as "owner/name"
+	Exclude  []string `yaml:"exclude" toml:"exclude"`
+	ReadMode string   `yaml:"readMode" toml:"readMode"`
+	// Default to true
+	Enabled *bool `yaml:"enabled" toml:"enabled"`
+}
+
+var configFileExts = []string{".yml", ".yaml", ".toml"}
+
+// defaultConfigPath returns the config file of the profile in $XDG_CONFIG_HOME/korat.
+// It returns an empty string if the file does not exist.
+func defaultConfigPath(profile string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	for _, ext := range configFileExts {
+		p := filepath.Join(dir, profile+ext)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+func LoadFileConfig(path string) (*FileConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &FileConfig{}
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(b, c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(b), c)
+		if err == nil && len(md.Undecoded()) != 0 {
+			err = errors.Errorf("Unknown keys: %v", md.Undecoded())
+		}
+	default:
+		return nil, errors.Errorf("Config file must be YAML or TOML: %s", path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse %s", path)
+	}
+	return c, nil
+}
+
+// validate checks the config without the database, so an invalid file changes nothing.
+func (c *FileConfig) validate() error {
+	accountNames := make(map[string]bool)
+	for _, a := range c.Accounts {
+		if a.Name == "" {
+			return errors.New("Account name is required")
+		}
+		if accountNames[a.Name] {
+			return errors.Errorf("Account %s is defined twice", a.Name)
+		}
+		accountNames[a.Name] = true
+
+		channels := make(map[string]ChannelConfig)
+		for _, ch := range a.Channels {
+			if ch.Name == "" {
+				return errors.Errorf("Channel name is required in account %s", a.Name)
+			}
+			if _, ok := channels[ch.Name]; ok {
+				return errors.Errorf("Channel %s is defined twice in account %s", ch.Name, a.Name)
+			}
+			channels[ch.Name] = ch
+		}
+
+		for _, ch := range a.Channels {
+			if err := ch.validate(channels); err != nil {
+				return errors.Wrapf(err, "Channel %s in account %s", ch.Name, a.Name)
+			}
+			if a.App != nil {
+				if err := checkAppChannel(ch.System, ch.Queries); err != nil {
+					return errors.Wrapf(err, "Channel %s in account %s", ch.Name, a.Name)
+				}
+			}
+		}
+		for _, ch := range a.Channels {
+			if err := checkChannelConfigCycle(ch, channels, map[string]bool{}); err != nil {
+				return errors.Wrapf(err, "Account %s", a.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (ch ChannelConfig) validate(channels map[string]ChannelConfig) error {
+	for _, q := range ch.Queries {
+		if q == "" {
+			return errors.New("Query must not be empty")
+		}
+	}
+	if ch.ReadMode != "" && ch.ReadMode != ReadModeGlobal && ch.ReadMode != ReadModeChannel {
+		return errors.Errorf("Unknown read mode: %s", ch.ReadMode)
+	}
+
+	if _, ok := virtualChannelOperators[ch.System]; ok {
+		if len(ch.Sources) == 0 {
+			return errors.New("Virtual channels require sources")
+		}
+		if len(ch.Queries) != 0 || len(ch.Exclude) != 0 {
+			return errors.New("Virtual channels cannot have queries or exclude")
+		}
+		if ch.ReadMode == ReadModeChannel {
+			return errors.New("Virtual channels cannot have the channel read mode")
+		}
+		for _, s := range ch.Sources {
+			if _, ok := channels[s]; !ok {
+				return errors.Errorf("Source channel %s does not exist", s)
+			}
+		}
+		return nil
+	}
+
+	if len(ch.Sources) != 0 {
+		return errors.New("Only virtual channels can have sources")
+	}
+	for _, r := range ch.Exclude {
+		if _, _, err := splitRepositoryName(r); err != nil {
+			return err
+		}
+	}
+	needQueries := true
+	if ch.System != "" {
+		var ok bool
+		needQueries, ok = systemChannelKinds[ch.System]
+		if !ok {
+			return errors.Errorf("%s is not a valid system type.", ch.System)
+		}
+	}
+	if needQueries && len(ch.Queries) == 0 {
+		return errors.New("Queries are required")
+	}
+	return nil
+}
+
+func checkChannelConfigCycle(ch ChannelConfig, channels map[string]ChannelConfig, visiting map[string]bool) error {
+	if visiting[ch.Name] {
+		return errors.Errorf("Channel %s refers to itself", ch.Name)
+	}
+	visiting[ch.Name] = true
+	defer delete(visiting, ch.Name)
+	for _, s := range ch.Sources {
+		if err := checkChannelConfigCycle(channels[s], channels, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconcileFileConfig applies the config to the accounts and channels tables.
+func ReconcileFileConfig(ctx context.Context, c *FileConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	for _, a := range c.Accounts {
+		if a.App != nil {
+			if a.Token.Env != "" || len(a.Token.Command) != 0 {
+				return errors.Errorf("Account %s: token and app cannot be used together", a.Name)
+			}
+			if err := a.App.validate(); err != nil {
+				return errors.Wrapf(err, "Account %s", a.Name)
+			}
+			if _, err := a.App.privateKey(); err != nil {
+				return errors.Wrapf(err, "Account %s", a.Name)
+			}
+			continue
+		}
+		if a.Token.Literal != "" {
+			return errors.Errorf("Account %s: token must be env or command", a.Name)
+		}
+		if _, err := a.Token.resolve(ctx); err != nil {
+			return errors.Wrapf(err, "Account %s", a.Name)
+		}
+	}
+
+	return txGorm(func(tx *gorm.DB) error {
+		accountIDs := make([]int, 0, len(c.Accounts))
+		channelIDs := make([]int, 0)
+		for _, ac := range c.Accounts {
+			a := Account{}
+			res := tx.Where("displayName = ?", ac.Name).First(&a)
+			if res.Error != nil && !res.RecordNotFound() {
+				return errors.WithStack(res.Error)
+			}
+			if !res.RecordNotFound() && !a.Managed {
+				return errors.Errorf("Account %s already exists, and it is not managed by the config file", ac.Name)
+			}
+			a.DisplayName = ac.Name
+			a.UrlBase = firstNonEmpty(ac.UrlBase, defaultUrlBase)
+			a.ApiUrlBase = firstNonEmpty(ac.ApiUrlBase, defaultApiUrlBase)
+			// Only the reference is saved, and the token is resolved when it is used.
+			if err := a.setTokenRef(ac.Token); err != nil {
+				return err
+			}
+			a.AppID, a.AppInstallationID, a.AppPrivateKeyFile = sql.NullInt64{}, sql.NullInt64{}, sql.NullString{}
+			if ac.App != nil {
+				a.setAppConfig(*ac.App)
+			}
+			a.Managed = true
+			if err := validateAccount(ctx, &a); err != nil {
+				return errors.Wrapf(err, "Account %s", ac.Name)
+			}
+			if err := tx.Save(&a).Error; err != nil {
+				return errors.WithStack(err)
+			}
+			accountIDs = append(accountIDs, a.ID)
+
+			ids, err := reconcileChannels(ctx, tx, a.ID, ac.Channels)
+			if err != nil {
+				return errors.Wrapf(err, "Account %s", ac.Name)
+			}
+			channelIDs = append(channelIDs, ids...)
+		}
+
+		// 0 is not a valid ID. It avoids an empty list, which is NULL in gorm.
+		channelIDs = append(channelIDs, 0)
+		accountIDs = append(accountIDs, 0)
+		removedAccounts := `select id from accounts where managed = 1 AND id NOT IN (?)`
+		var removed []int
+		err := tx.Table("channels").
+			Where("(managed = 1 AND id NOT IN (?)) OR accountID IN ("+removedAccounts+")", channelIDs, accountIDs).
+			Pluck("id", &removed).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		b, err := newChannelIssuesQueryBuilder(tx)
+		if err != nil {
+			return err
+		}
+		if err := checkChannelsDeletable(b, removed); err != nil {
+			return errors.Wrap(err, "Channels removed from the config file cannot be deleted")
+		}
+		if err := deleteChannels(tx, removed); err != nil {
+			return err
+		}
+
+		// Views of the removed accounts are deleted by the foreign key.
+		err = tx.Exec(`delete from accounts where managed = 1 AND id NOT IN (?)`, accountIDs).Error
+		return errors.WithStack(err)
+	})
+}
+
+// reconcileChannels saves the channels of the account, and returns their IDs.
+func reconcileChannels(ctx context.Context, tx *gorm.DB, accountID int, chs []ChannelConfig) ([]int, error) {
+	res := make([]int, 0, len(chs))
+	idByName := make(map[string]int, len(chs))
+	saved := make([]*Channel, len(chs))
+
+	// Source channels are set after all channels are saved, because virtual channels refer to their IDs.
+	for idx, cc := range chs {
+		c := &Channel{}
+		r := tx.Where("accountID = ? AND displayName = ?", accountID, cc.Name).First(c)
+		if r.Error != nil && !r.RecordNotFound() {
+			return nil, errors.WithStack(r.Error)
+		}
+		if !r.RecordNotFound() && !c.Managed {
+			return nil, errors.Errorf("Channel %s already exists, and it is not managed by the config file", cc.Name)
+		}
+		prevReadMode := c.ReadMode
+
+		queries := cc.Queries
+		if queries == nil {
+			queries = []string{}
+		}
+		qs, err := json.Marshal(queries)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		c.DisplayName = cc.Name
+		c.AccountID = accountID
+		c.QueriesRaw = string(qs)
+		c.System = sql.NullString{String: cc.System, Valid: cc.System != ""}
+		c.SourceChannelIDsRaw = sql.NullString{}
+		c.ExcludedRepositoriesRaw = sql.NullString{}
+		if len(cc.Exclude) != 0 {
+			ex, err := json.Marshal(cc.Exclude)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			c.ExcludedRepositoriesRaw = sql.NullString{String: string(ex), Valid: true}
+		}
+		c.ReadMode = firstNonEmpty(cc.ReadMode, ReadModeGlobal)
+		// Channels are ordered as in the file.
+		c.Position = idx + 1
+		c.Enabled = cc.Enabled == nil || *cc.Enabled
+		c.Managed = true
+		if err := tx.Save(c).Error; err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := deleteStaleChannelIssues(tx, c); err != nil {
+			return nil, err
+		}
+		if c.ReadMode == ReadModeChannel && prevReadMode != ReadModeChannel {
+			// Same as UpdateChannelReadMode
+			err := tx.Exec(`
+				update channel_issues
+				set alreadyRead = (select i.alreadyRead from issues as i where i.id = channel_issues.issueID)
+				where channelID = ?
+			`, c.ID).Error
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		idByName[cc.Name] = c.ID
+		saved[idx] = c
+		res = append(res, c.ID)
+	}
+
+	for idx, cc := range chs {
+		if len(cc.Sources) == 0 {
+			continue
+		}
+		ids := make([]int, len(cc.Sources))
+		for i, s := range cc.Sources {
+			ids[i] = idByName[s]
+		}
+		b, err := json.Marshal(ids)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = tx.Exec(`update channels set sourceChannelIDs = ? where id = ?`, string(b), saved[idx].ID).Error
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return res, nil
+}
+
+// StartWatchFileConfig reconciles the config file when it is modified, and restarts workers with the new channels.
+func StartWatchFileConfig(ctx context.Context, path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	modTime := stat.ModTime()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			log.Printf("%+v\n", errors.WithStack(err))
+			continue
+		}
+		if stat.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = stat.ModTime()
+
+		log.Printf("Reconcile %s\n", path)
+		c, err := LoadFileConfig(path)
+		if err == nil {
+			err = ReconcileFileConfig(ctx, c)
+		}
+		if err == nil {
+			err = RestartAllAccountWorkers()
+		}
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+}
@@ -16,27 +16,37 @@ func StartDetermineMerged(ctx context.Context) error {
 	}
 
 	for _, a := range accounts {
-		go func(a Account) {
-			for {
-				childCtx, cancel := context.WithCancel(ctx)
-				err := errors.WithStack(startDetermineMerged(childCtx, a))
-				log.Printf("%+v\n", err)
-				err = sendErrToSlack(err)
-				if err != nil {
-					log.Printf("%+v\n", err)
-				}
-				cancel()
-			}
-		}(a)
+		go startDetermineMergedWorker(accountWorkerContext(a.ID), a)
 	}
 	return nil
 }
 
+// startDetermineMergedWorker determines merged pull requests of the account until ctx is cancelled.
+func startDetermineMergedWorker(ctx context.Context, a Account) {
+	for ctx.Err() == nil {
+		childCtx, cancel := context.WithCancel(ctx)
+		err := errors.WithStack(startDetermineMerged(childCtx, a))
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%+v\n", err)
+		err = sendErrToSlack(err)
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+}
+
 func startDetermineMerged(ctx context.Context, account Account) error {
-	client := ghClient(ctx, account.AccessToken)
+	client := ghClient(ctx, account)
 
 	for {
-		time.Sleep(3 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
 		i := Issue{}
 		db := SelectUndeterminedPullRequest(account.ID).First(&i)
 		if db.RecordNotFound() {
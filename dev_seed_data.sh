@@ -2,51 +2,15 @@
 
 set -e
 
-sqlite3 ~/.cache/korat/development.sqlite3 << END
-  PRAGMA foreign_keys = ON;
-
-  replace into accounts(id, displayName, urlBase, apiUrlBase, accessToken) VALUES (
-    1,
-    'GitHub.com',
-    'https://github.com',
-    'https://api.github.com',
-    '${GITHUB_ACCESS_TOKEN}'
-  );
-
-  replace into channels (id, displayName, queries, accountID) VALUES (
-    1,
-    'me',
-    '["involves:pocke","user:pocke"]',
-    1
-  );
-
-  replace into channels (id, displayName, queries, accountID) VALUES (
-    2,
-    'RuboCop',
-    '["user:rubocop-hq"]',
-    1
-  );
-
-  replace into channels (id, displayName, queries, accountID, system) VALUES (
-    3,
-    'Teams',
-    '[]',
-    1,
-    'teams'
-  );
-
-  replace into channels (id, displayName, queries, accountID, system) VALUES (
-    4,
-    'Watching',
-    '[]',
-    1,
-    'watching'
-  );
-
-  replace into channels (id, displayName, queries, accountID) VALUES (
-    5,
-    'By me',
-    '["author:pocke"]',
-    1
-  );
-END
+korat-go account add --name GitHub.com --token-env GITHUB_ACCESS_TOKEN
+account=$(korat-go account list | awk '$2 == "GitHub.com" { id = $1 } END { print id }')
+
+korat-go channel add --account "$account" --name me --query involves:pocke --query user:pocke
+korat-go channel add --account "$account" --name RuboCop --query user:rubocop-hq
+korat-go channel add --account "$account" --name Teams --system teams
+korat-go channel add --account "$account" --name Watching --system watching
+korat-go channel add --account "$account" --name 'By me' --query author:pocke
+
+rubocop=$(korat-go channel list | awk '$3 == "RuboCop" { id = $1 } END { print id }')
+teams=$(korat-go channel list | awk '$3 == "Teams" { id = $1 } END { print id }')
+korat-go channel add --account "$account" --name 'Teams minus RuboCop' --system difference --sources "$teams,$rubocop"
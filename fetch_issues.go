@@ -14,8 +14,6 @@ import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/motemen/go-loghttp/global"
 	"github.com/pkg/errors"
-
-	"golang.org/x/oauth2"
 )
 
 func StartFetchIssues(ctx context.Context) error {
@@ -31,24 +29,30 @@ func StartFetchIssues(ctx context.Context) error {
 	}
 
 	for _, q := range qs {
-		go func(q ActualQuery) {
-			for {
-				childCtx, cancel := context.WithCancel(ctx)
-				err := errors.WithStack(startFetchIssuesWithChannel(childCtx, q))
-				log.Printf("%+v\n", err)
-				err = sendErrToSlack(err)
-				if err != nil {
-					log.Printf("%+v\n", err)
-				}
-				cancel()
-				time.Sleep(1 * time.Second)
-			}
-		}(q)
+		go startFetchIssuesWorker(accountWorkerContext(q.account.ID), q)
 	}
 
 	return nil
 }
 
+// startFetchIssuesWorker fetches issues of the query until ctx is cancelled.
+func startFetchIssuesWorker(ctx context.Context, q ActualQuery) {
+	for ctx.Err() == nil {
+		childCtx, cancel := context.WithCancel(ctx)
+		err := errors.WithStack(startFetchIssuesWithChannel(childCtx, q))
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%+v\n", err)
+		err = sendErrToSlack(err)
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
 type SlackMsg struct {
 	Text string `json:"text"`
 }
@@ -67,7 +71,8 @@ func sendErrToSlack(err error) error {
 }
 
 func startFetchIssuesWithChannel(ctx context.Context, q ActualQuery) error {
-	errCh := make(chan error)
+	// Buffered for both goroutines, so the other one does not leak after the first error.
+	errCh := make(chan error, 2)
 	err := startFetchIssuesFor(ctx, q, errCh)
 	if err != nil {
 		return err
@@ -82,7 +87,7 @@ func startFetchIssuesWithChannel(ctx context.Context, q ActualQuery) error {
 }
 
 func startFetchIssuesFor(ctx context.Context, q ActualQuery, errCh chan<- error) error {
-	client := ghClient(ctx, q.accessToken)
+	client := ghClient(ctx, q.account)
 	go func() {
 		errCh <- fetchOldIssues(ctx, client, q)
 	}()
@@ -114,7 +119,9 @@ func fetchAndSaveIssue(ctx context.Context, client *github.Client, q ActualQuery
 			PerPage: 100,
 		},
 	}
-	deqSearchIssueQueue()
+	if err := deqSearchIssueQueue(ctx); err != nil {
+		return -1, err
+	}
 	issues, _, err := client.Search.Issues(ctx, query.build(), opt)
 	if err != nil {
 		return -1, err
@@ -123,7 +130,11 @@ func fetchAndSaveIssue(ctx context.Context, client *github.Client, q ActualQuery
 	cidMap := make(map[int][]github.Issue)
 	for _, i := range issues.Issues {
 		for _, cond := range q.conditions {
-			if cond.satisfy(i) {
+			ok, err := cond.satisfy(ctx, client, i)
+			if err != nil {
+				return -1, err
+			}
+			if ok {
 				cidMap[cond.channel.ID] = append(cidMap[cond.channel.ID], i)
 			}
 		}
@@ -201,26 +212,66 @@ func fetchNewIssues(ctx context.Context, client *github.Client, q ActualQuery) e
 	}
 
 	for {
-		var newestUpdatedAt time.Time
-		i := Issue{}
-		res := EdgeIssueTime(qid, "desc").First(&i)
-		if res.RecordNotFound() {
-			newestUpdatedAt = time.Now().UTC()
-		} else if res.Error != nil {
-			return res.Error
-		} else {
-			newestUpdatedAt, err = parseTime(i.UpdatedAt)
-			if err != nil {
-				return err
-			}
+		err := fetchNewIssuesOnce(ctx, client, q, qid)
+		if err != nil {
+			return err
 		}
+	}
+}
 
-		fq := &fetchIssueQuery{base: q.query, cond: "updated:>=" + fmtTime(newestUpdatedAt)}
-		_, err = fetchAndSaveIssue(ctx, client, q, fq, "asc")
+func fetchNewIssuesOnce(ctx context.Context, client *github.Client, q ActualQuery, qid int) error {
+	var newestUpdatedAt time.Time
+	i := Issue{}
+	res := EdgeIssueTime(qid, "desc").First(&i)
+	if res.RecordNotFound() {
+		newestUpdatedAt = time.Now().UTC()
+	} else if res.Error != nil {
+		return res.Error
+	} else {
+		var err error
+		newestUpdatedAt, err = parseTime(i.UpdatedAt)
 		if err != nil {
 			return err
 		}
 	}
+
+	fq := &fetchIssueQuery{base: q.query, cond: "updated:>=" + fmtTime(newestUpdatedAt)}
+	_, err := fetchAndSaveIssue(ctx, client, q, fq, "asc")
+	return err
+}
+
+// FetchNewIssuesOnce fetches new issues of each actual query one time.
+// It continues with other queries even if a query fails, and returns the number of failed queries.
+func FetchNewIssuesOnce(ctx context.Context) (int, error) {
+	chs := make([]Channel, 0)
+	err := gormConn.Preload("Account").Find(&chs).Error
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	qs, err := BuildActualQuery(ctx, chs)
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, q := range qs {
+		var qid int
+		err := txGorm(func(tx *gorm.DB) error {
+			q := Query{Query: q.query}
+			err := tx.FirstOrCreate(&q, q).Error
+			qid = q.ID
+			return err
+		})
+		if err == nil {
+			err = fetchNewIssuesOnce(ctx, ghClient(ctx, q.account), q, qid)
+		}
+		if err != nil {
+			log.Printf("%+v\n", errors.WithStack(err))
+			failed++
+		}
+	}
+	return failed, nil
 }
 
 func notifyUnreadCount(ctx context.Context, issues []github.Issue) error {
@@ -234,28 +285,21 @@ func notifyUnreadCount(ctx context.Context, issues []github.Issue) error {
 		return err
 	}
 
-	for _, cnt := range cnts {
-		unreadCountNotifier.Notify(cnt)
-	}
-	return nil
-}
-
-func ghClient(ctx context.Context, accessToken string) *github.Client {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: accessToken},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-
-	return github.NewClient(tc)
+	return NotifyUnreadCounts(ctx, cnts)
 }
 
 var searchIssueQueue = make(chan struct{}, 2)
 
-// For rate limit
-func deqSearchIssueQueue() {
-	searchIssueQueue <- struct{}{}
+// For rate limit. It returns an error if ctx is done while waiting.
+func deqSearchIssueQueue(ctx context.Context) error {
+	select {
+	case searchIssueQueue <- struct{}{}:
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
 	go func() {
 		time.Sleep(5 * time.Second)
 		<-searchIssueQueue
 	}()
+	return nil
 }
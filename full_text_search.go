@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SQLite marks matched terms with these control characters,
+// and they are replaced with <mark> after the text is HTML escaped.
+const (
+	highlightOpen  = "\x02"
+	highlightClose = "\x03"
+)
+
+type FullTextSearchQuery struct {
+	query string
+	// 0 means all channels
+	channelID int
+	limit     int
+}
+
+type FullTextSearchResult struct {
+	*IssueOld
+	// HTML escaped title and a part of body, matched terms are surrounded by <mark> and </mark>.
+	TitleHighlight string
+	BodySnippet    string
+}
+
+func SearchIssuesFullText(ctx context.Context, q *FullTextSearchQuery) ([]*FullTextSearchResult, error) {
+	res := make([]*FullTextSearchResult, 0)
+	match := buildFullTextQuery(q.query)
+	if match == "" {
+		return res, nil
+	}
+
+	channelCond := ""
+	args := []interface{}{highlightOpen, highlightClose, highlightOpen, highlightClose, match}
+	if q.channelID != 0 {
+		channelCond = `AND exists (select 1 from channel_issues as ci where ci.issueID = i.id AND ci.channelID = ?)`
+		args = append(args, q.channelID)
+	}
+	args = append(args, q.limit)
+
+	rows, err := gormConn.Raw(fmt.Sprintf(`
+		select
+			%s,
+			highlight(issues_fts, 0, ?, ?),
+			snippet(issues_fts, 1, ?, ?, '...', 32)
+		from
+			issues_fts,
+			issues as i,
+			github_users as u
+		where
+			i.id = issues_fts.rowid AND
+			u.id = i.userID AND
+			issues_fts match ?
+			%s
+		order by
+			bm25(issues_fts)
+		limit
+			?
+		;
+	`, selectIssueColumns, channelCond), args...).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	issues := make([]*IssueOld, 0)
+	for rows.Next() {
+		r := &FullTextSearchResult{}
+		i, err := scanIssue(rows, &r.TitleHighlight, &r.BodySnippet)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.IssueOld = i
+		r.TitleHighlight = highlightHTML(r.TitleHighlight)
+		r.BodySnippet = highlightHTML(r.BodySnippet)
+		res = append(res, r)
+		issues = append(issues, i)
+	}
+
+	if err := includeRelationsToIssues(ctx, issues); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// highlightHTML escapes s, and replaces the highlight markers with <mark> and </mark>.
+func highlightHTML(s string) string {
+	return strings.NewReplacer(highlightOpen, "<mark>", highlightClose, "</mark>").Replace(html.EscapeString(s))
+}
+
+// buildFullTextQuery quotes each word of the user input,
+// so FTS5 syntax in the input does not cause a syntax error.
+// The words are combined with AND.
+func buildFullTextQuery(q string) string {
+	words := strings.Fields(q)
+	for idx, w := range words {
+		words[idx] = `"` + strings.Replace(w, `"`, `""`, -1) + `"`
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/go-github/v21/github"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// AppConfig authenticates an account as an installation of a GitHub App instead of an access token.
+// Installation tokens are valid for an hour, so they are minted again before they expire.
+type AppConfig struct {
+	ID             int64 `yaml:"id" toml:"id"`
+	InstallationID int64 `yaml:"installationID" toml:"installationID"`
+	// Path to the PEM encoded private key of the App. It is read when a token is minted.
+	PrivateKeyFile string `yaml:"privateKeyFile" toml:"privateKeyFile"`
+}
+
+func (c AppConfig) validate() error {
+	if c.ID == 0 || c.InstallationID == 0 || c.PrivateKeyFile == "" {
+		return errors.New("GitHub App requires id, installationID and privateKeyFile")
+	}
+	return nil
+}
+
+// privateKey reads and parses the private key file.
+func (c AppConfig) privateKey() ([]byte, error) {
+	path, err := homedir.Expand(c.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := jwt.ParseRSAPrivateKeyFromPEM(b); err != nil {
+		return nil, errors.Wrapf(err, "Invalid private key: %s", c.PrivateKeyFile)
+	}
+	return b, nil
+}
+
+// isApp returns true if the account is authenticated as a GitHub App installation.
+func (a Account) isApp() bool {
+	return a.AppID.Valid
+}
+
+// checkAppChannel returns an error if a channel of a GitHub App account uses APIs for users.
+// An installation token cannot list watched repositories and teams of the user, or search with @me.
+// Codeowners channels need the authenticated user and the teams of the user too.
+func checkAppChannel(system string, queries []string) error {
+	if system == "watching" || system == "teams" || system == "codeowners" {
+		return errors.Errorf("%s channels are not available for GitHub App accounts", system)
+	}
+	for _, q := range queries {
+		if strings.Contains(q, "@me") {
+			return errors.Errorf("@me is not available for GitHub App accounts: %q", q)
+		}
+	}
+	return nil
+}
+
+// checkAppChannels checks existing channels of the account, which is changed to a GitHub App account.
+func checkAppChannels(accountID int) error {
+	channels := make([]Channel, 0)
+	if err := gormConn.Where("accountID = ?", accountID).Find(&channels).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	for _, c := range channels {
+		qs, err := c.rawQueries()
+		if err != nil {
+			return err
+		}
+		if err := checkAppChannel(c.System.String, qs); err != nil {
+			return errors.Wrapf(err, "Channel %s", c.DisplayName)
+		}
+	}
+	return nil
+}
+
+func (a Account) appConfig() AppConfig {
+	return AppConfig{
+		ID:             a.AppID.Int64,
+		InstallationID: a.AppInstallationID.Int64,
+		PrivateKeyFile: a.AppPrivateKeyFile.String,
+	}
+}
+
+// setAppConfig makes the account a GitHub App account. It clears the access token.
+func (a *Account) setAppConfig(c AppConfig) {
+	a.AppID = sql.NullInt64{Int64: c.ID, Valid: true}
+	a.AppInstallationID = sql.NullInt64{Int64: c.InstallationID, Valid: true}
+	a.AppPrivateKeyFile = sql.NullString{String: c.PrivateKeyFile, Valid: true}
+	a.AccessToken = ""
+	a.TokenEnv = sql.NullString{}
+	a.TokenCommandRaw = sql.NullString{}
+}
+
+// authSource describes how the account is authenticated, without secrets.
+func (a Account) authSource() string {
+	if a.isApp() {
+		return "app:" + strconv.FormatInt(a.AppID.Int64, 10) + "/" + strconv.FormatInt(a.AppInstallationID.Int64, 10)
+	}
+	return a.tokenRef().String()
+}
+
+type appInstallation struct {
+	apiUrlBase     string
+	appID          int64
+	installationID int64
+}
+
+// Installation tokens are shared between clients, because ghClient is called for each worker.
+var appTokenCache = struct {
+	mu     sync.Mutex
+	tokens map[appInstallation]*oauth2.Token
+}{tokens: make(map[appInstallation]*oauth2.Token)}
+
+// appTokenSource returns an installation token of a GitHub App. It is renewed before the expiration.
+type appTokenSource struct {
+	ctx     context.Context
+	account Account
+}
+
+func (s *appTokenSource) key() appInstallation {
+	return appInstallation{
+		apiUrlBase:     s.account.ApiUrlBase,
+		appID:          s.account.AppID.Int64,
+		installationID: s.account.AppInstallationID.Int64,
+	}
+}
+
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	key := s.key()
+	appTokenCache.mu.Lock()
+	t, ok := appTokenCache.tokens[key]
+	appTokenCache.mu.Unlock()
+	if ok && time.Now().Add(time.Minute).Before(t.Expiry) {
+		return t, nil
+	}
+
+	// The lock is not held while minting, so other installations are not blocked by the request.
+	t, err := s.mint()
+	if err != nil {
+		return nil, err
+	}
+	appTokenCache.mu.Lock()
+	defer appTokenCache.mu.Unlock()
+	appTokenCache.tokens[key] = t
+	return t, nil
+}
+
+func (s *appTokenSource) invalidate() bool {
+	appTokenCache.mu.Lock()
+	defer appTokenCache.mu.Unlock()
+	delete(appTokenCache.tokens, s.key())
+	return true
+}
+
+func (s *appTokenSource) mint() (*oauth2.Token, error) {
+	conf := s.account.appConfig()
+	pem, err := conf.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := time.Now()
+	// The issued time is set to the past to allow clock drift.
+	appJWT, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+		Issuer:    strconv.FormatInt(conf.ID, 10),
+	}).SignedString(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	client := github.NewClient(&http.Client{
+		Transport: &oauth2.Transport{Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: appJWT})},
+	})
+	setApiUrlBase(client, s.account.ApiUrlBase)
+	it, _, err := client.Apps.CreateInstallationToken(s.ctx, conf.InstallationID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot create an installation token of GitHub App %d", conf.ID)
+	}
+	return &oauth2.Token{AccessToken: it.GetToken(), Expiry: it.GetExpiresAt()}, nil
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"os"
+	"path/filepath"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
-	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 )
 
@@ -27,6 +28,7 @@ type Issue struct {
 	Body          string
 	AlreadyRead   bool `gorm:"column:alreadyRead"`
 	Merged        NullBoolJSON
+	Muted         bool
 
 	User      *User
 	Labels    []*Label
@@ -51,9 +53,24 @@ type Account struct {
 	DisplayName string `gorm:"column:displayName"`
 	UrlBase     string `gorm:"column:urlBase"`
 	ApiUrlBase  string `gorm:"column:apiUrlBase"`
+	// AccessToken is encrypted by TokenCipher.
+	// It is empty if the token is taken from TokenEnv or TokenCommand.
 	AccessToken string `gorm:"column:accessToken"`
+	// Name of an environment variable
+	TokenEnv sql.NullString `gorm:"column:tokenEnv" json:"-"`
+	// JSON array of a command and its arguments, which prints the token
+	TokenCommandRaw sql.NullString `gorm:"column:tokenCommand" json:"-"`
+	// GitHub App installation. They are set instead of the token for App accounts.
+	AppID             sql.NullInt64  `gorm:"column:appID" json:"-"`
+	AppInstallationID sql.NullInt64  `gorm:"column:appInstallationID" json:"-"`
+	AppPrivateKeyFile sql.NullString `gorm:"column:appPrivateKeyFile" json:"-"`
+	// Login of the token owner. It is set when the account is created via the API.
+	Login NullStringJSON `gorm:"column:login"`
+	// True if the account is defined in the config file
+	Managed bool
 
 	Channels []Channel
+	Views    []View
 }
 
 type Channel struct {
@@ -62,6 +79,18 @@ type Channel struct {
 	System      sql.NullString
 	QueriesRaw  string `gorm:"column:queries"`
 	AccountID   int    `gorm:"column:accountID"`
+	// JSON array of channel IDs for virtual channels
+	SourceChannelIDsRaw sql.NullString `gorm:"column:sourceChannelIDs"`
+	// ReadModeGlobal or ReadModeChannel
+	ReadMode string `gorm:"column:readMode"`
+	// JSON array of "owner/name". Issues in the repositories are not imported to the channel.
+	ExcludedRepositoriesRaw sql.NullString `gorm:"column:excludedRepositories"`
+	// Channels are listed in ascending order of Position.
+	Position int `gorm:"column:position"`
+	// Issues of disabled channels are not fetched.
+	Enabled bool `gorm:"column:enabled"`
+	// True if the channel is defined in the config file
+	Managed bool
 
 	Account Account
 }
@@ -71,25 +100,30 @@ type Query struct {
 	Query string
 }
 
-type MigrationInfo struct {
-	ID int
-}
-
-func (m MigrationInfo) TableName() string {
-	return "migration_info"
-}
-
 func (c Channel) Queries(ctx context.Context) ([]string, error) {
 	if c.System.Valid == true {
-		client := ghClient(ctx, c.Account.AccessToken)
-		return buildSystemQueries(ctx, c.System.String, client)
+		client := ghClient(ctx, c.Account)
+		return buildSystemQueries(ctx, c, client)
 	} else {
-		res := make([]string, 0)
-		err := json.Unmarshal([]byte(c.QueriesRaw), &res)
-		return res, err
+		return c.rawQueries()
 	}
 }
 
+func (c Channel) ExcludedRepositories() ([]string, error) {
+	res := make([]string, 0)
+	if !c.ExcludedRepositoriesRaw.Valid {
+		return res, nil
+	}
+	err := json.Unmarshal([]byte(c.ExcludedRepositoriesRaw.String), &res)
+	return res, errors.WithStack(err)
+}
+
+func (c Channel) rawQueries() ([]string, error) {
+	res := make([]string, 0)
+	err := json.Unmarshal([]byte(c.QueriesRaw), &res)
+	return res, err
+}
+
 func EdgeIssueTime(queryID int, order string) *gorm.DB {
 	return gormConn.Joins("JOIN channel_issues as ci ON issues.id = ci.issueID").
 		Where("ci.queryID = ?", queryID).
@@ -111,14 +145,21 @@ func txGorm(f func(*gorm.DB) error) error {
 	return tx.Commit().Error
 }
 
-func init() {
-	fname, err := homedir.Expand("~/.cache/korat/development.sqlite3")
-	if err != nil {
-		panic(err)
+// openDB opens the database and sets it to gormConn.
+// The parent directory is created if it does not exist.
+func openDB(fname string) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return errors.WithStack(err)
 	}
-	db, err := gorm.Open("sqlite3", fname)
+	// Foreign keys are enabled by the DSN, so that every connection in the pool enables them.
+	db, err := gorm.Open("sqlite3", fname+"?_foreign_keys=1")
 	if err != nil {
-		panic(err)
+		return errors.Wrapf(err, "Cannot open database %s", fname)
+	}
+	// sqlite3 opens the file lazily, so check it here to report a bad path.
+	if err := db.DB().Ping(); err != nil {
+		db.Close()
+		return errors.Wrapf(err, "Cannot open database %s", fname)
 	}
 
 	// Will not set CreatedAt and UpdatedAt on .Create() call
@@ -126,12 +167,13 @@ func init() {
 	// Will not update UpdatedAt on .Save() call
 	db.Callback().Update().Remove("gorm:update_time_stamp")
 	// db.LogMode(true)
-	err = db.Exec("PRAGMA foreign_keys = ON;").Error
-	if err != nil {
-		panic(err)
-	}
 
 	gormConn = db
+	dbPath = fname
+	return nil
 }
 
 var gormConn *gorm.DB
+
+// dbPath is the file of gormConn.
+var dbPath string
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// The server records the time in settings periodically,
+// so that commands which cannot run under the server, such as rekey, detect it.
+const (
+	settingServerHeartbeat  = "serverHeartbeat"
+	serverHeartbeatInterval = 10 * time.Second
+	// The server is regarded as stopped if it misses some heartbeats.
+	serverHeartbeatTimeout = 3 * serverHeartbeatInterval
+)
+
+// StartServerHeartbeat records the heartbeat periodically until ctx is cancelled.
+func StartServerHeartbeat(ctx context.Context) error {
+	if err := setSetting(gormConn, settingServerHeartbeat, fmtTime(time.Now())); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(serverHeartbeatInterval):
+			}
+			if err := setSetting(gormConn, settingServerHeartbeat, fmtTime(time.Now())); err != nil {
+				log.Printf("%+v\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// checkServerStopped returns an error if a server has recorded the heartbeat recently.
+func checkServerStopped(db *gorm.DB) error {
+	v, ok, err := getSetting(db, settingServerHeartbeat)
+	if err != nil || !ok {
+		return err
+	}
+	t, err := parseTime(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if time.Since(t) < serverHeartbeatTimeout {
+		wait := (serverHeartbeatTimeout - time.Since(t)).Round(time.Second)
+		return errors.Errorf("The server is using the database. Stop it first. If it is already stopped, retry in %s", wait)
+	}
+	return nil
+}
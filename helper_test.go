@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB opens a migrated database in a temporary directory as gormConn.
+// It skips the test if SQLite is built without FTS5.
+func openTestDB(tb testing.TB) {
+	tb.Helper()
+	if err := openDB(filepath.Join(tb.TempDir(), "test.sqlite3")); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { gormConn.Close() })
+	if err := checkFTS5(); err != nil {
+		tb.Skip(err)
+	}
+	if err := dbMigrate(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// insertTestIssues inserts unread open issues whose IDs are 1..n.
+func insertTestIssues(tb testing.TB, n int) {
+	tb.Helper()
+	mustExec(tb, `insert into github_users (id, login, avatarURL) values (1, 'user', '')`)
+	for id := 1; id <= n; id++ {
+		mustExec(tb, `
+			insert into issues
+			(id, number, title, userID, repoOwner, repoName, state, locked, comments, createdAt, updatedAt, isPullRequest, body, alreadyRead)
+			values (?, ?, ?, 1, 'owner', 'repo', 'open', 0, 0, '2020-01-01T00:00:00Z', '2020-01-01T00:00:00Z', 0, '', 0)
+		`, id, id, fmt.Sprintf("Issue %d", id))
+	}
+}
+
+func mustExec(tb testing.TB, sql string, args ...interface{}) {
+	tb.Helper()
+	if err := gormConn.Exec(sql, args...).Error; err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// insertTestChannel inserts an account and a channel of the query "q", whose IDs are 1.
+func insertTestChannel(tb testing.TB, readMode string) {
+	tb.Helper()
+	a := &Account{DisplayName: "test", UrlBase: defaultUrlBase, ApiUrlBase: defaultApiUrlBase}
+	if err := gormConn.Create(a).Error; err != nil {
+		tb.Fatal(err)
+	}
+	c, err := NewChannel(a.ID, "test", []string{"q"}, "", nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c.ReadMode = readMode
+	if err := gormConn.Create(c).Error; err != nil {
+		tb.Fatal(err)
+	}
+	if err := gormConn.Create(&Query{Query: "q"}).Error; err != nil {
+		tb.Fatal(err)
+	}
+}
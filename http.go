@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/pkg/errors"
@@ -32,9 +35,37 @@ func StartHTTPServer(port int) {
 
 	e.GET("/accounts", accountsIndex)
 	e.POST("/accounts", accountsCreate)
+	e.PATCH("/accounts/:accountID", accountsUpdate)
+	e.DELETE("/accounts/:accountID", accountsDelete)
 	e.GET("/channels/:channelID/issues", issuesIndex)
+	e.POST("/channels", channelsCreate)
+	e.PATCH("/channels/order", channelsReorder)
+	e.PATCH("/channels/:channelID", channelsUpdate)
+	e.DELETE("/channels/:channelID", channelsDelete)
+	e.PATCH("/channels/:channelID/readMode", channelsUpdateReadMode)
+	e.GET("/inbox/issues", inboxIssuesIndex)
+	e.GET("/views", viewsIndex)
+	e.POST("/views", viewsCreate)
+	e.PATCH("/views/:viewID", viewsUpdate)
+	e.DELETE("/views/:viewID", viewsDelete)
+	e.GET("/views/:viewID/issues", viewIssuesIndex)
+	e.GET("/search/issues", issuesSearch)
+	e.GET("/issues/:issueID", issuesShow)
+	e.PATCH("/issues/markAsRead", issuesBulkMarkAsRead)
+	e.PATCH("/issues/markAsUnread", issuesBulkMarkAsUnread)
 	e.PATCH("/issues/:issueID/markAsRead", issuesMarkAsRead)
 	e.PATCH("/issues/:issueID/markAsUnread", issuesMarkAsUnread)
+	e.PATCH("/issues/:issueID/star", issuesStar)
+	e.PATCH("/issues/:issueID/unstar", issuesUnstar)
+	e.PATCH("/issues/:issueID/tags", issuesUpdateTags)
+	e.PATCH("/issues/:issueID/note", issuesUpdateNote)
+	e.GET("/tags", tagsIndex)
+	e.GET("/actions", actionsIndex)
+	e.POST("/actions/undo", actionsUndo)
+	e.PATCH("/issues/:issueID/mute", issuesMute)
+	e.PATCH("/issues/:issueID/unmute", issuesUnmute)
+	e.PATCH("/issues/:issueID/snooze", issuesSnooze)
+	e.PATCH("/issues/:issueID/unsnooze", issuesUnsnooze)
 
 	e.GET("/ws", wsHandler)
 	e.Logger.Fatal(e.Start(fmt.Sprintf(":%d", port)))
@@ -42,7 +73,8 @@ func StartHTTPServer(port int) {
 
 func accountsIndex(c echo.Context) error {
 	accounts := make([]Account, 0)
-	if err := gormConn.Preload("Channels").Find(&accounts).Error; err != nil {
+	orderChannels := func(db *gorm.DB) *gorm.DB { return db.Order("position, id") }
+	if err := gormConn.Preload("Channels", orderChannels).Preload("Views").Find(&accounts).Error; err != nil {
 		return err
 	}
 
@@ -50,19 +82,97 @@ func accountsIndex(c echo.Context) error {
 }
 
 func accountsCreate(c echo.Context) error {
-	a := Account{}
-	err := c.Bind(a)
+	p := AccountParams{}
+	if err := c.Bind(&p); err != nil {
+		return err
+	}
+	a := &Account{}
+	if err := saveAccountParams(c, a, p); err != nil {
+		return err
+	}
+	if err := CreateAccount(c.Request().Context(), a); err != nil {
+		return err
+	}
+	RestartAccountWorkers(a.ID)
+	return c.JSON(http.StatusCreated, a)
+}
+
+func accountsUpdate(c echo.Context) error {
+	a, err := findAccount(c)
+	if err != nil {
+		return err
+	}
+	p := AccountParams{}
+	if err := c.Bind(&p); err != nil {
+		return err
+	}
+	if err := saveAccountParams(c, a, p); err != nil {
+		return err
+	}
+	if err := UpdateAccount(c.Request().Context(), a); err != nil {
+		return unprocessableHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+func accountsDelete(c echo.Context) error {
+	a, err := findAccount(c)
 	if err != nil {
 		return err
 	}
-	return gormConn.Create(a).Error
+	if err := DeleteAccount(c.Request().Context(), a.ID); err != nil {
+		return unprocessableHTTPError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// saveAccountParams applies p to a and verifies the credentials with the API.
+// Invalid params are responded as 422.
+func saveAccountParams(c echo.Context, a *Account, p AccountParams) error {
+	if err := p.apply(a); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	a.UrlBase = firstNonEmpty(a.UrlBase, defaultUrlBase)
+	a.ApiUrlBase = firstNonEmpty(a.ApiUrlBase, defaultApiUrlBase)
+	ctx := c.Request().Context()
+	if err := validateAccount(ctx, a); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	// An existing account may be changed to a GitHub App account.
+	if a.ID != 0 && a.isApp() {
+		if err := checkAppChannels(a.ID); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+	if err := VerifyAccount(ctx, a); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return nil
+}
+
+func findAccount(c echo.Context) (*Account, error) {
+	accountID, err := strconv.Atoi(c.Param("accountID"))
+	if err != nil {
+		return nil, err
+	}
+	a := &Account{}
+	res := gormConn.Where("id = ?", accountID).First(a)
+	if res.RecordNotFound() {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Account %d is not found", accountID))
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return a, nil
 }
 
 type SearchIssuesQuery struct {
-	page      int
-	perPage   int
-	channelID int
-	filter    *SearchIssueFilter
+	perPage    int
+	cursor     *IssuesCursor
+	sort       string
+	order      string
+	channelIDs []int
+	filter     *SearchIssueFilter
 }
 
 type SearchIssueFilter struct {
@@ -75,6 +185,45 @@ type SearchIssueFilter struct {
 	Closed bool
 	Open   bool
 	Merged bool
+
+	HasOpenLinkedPullRequest bool
+
+	// Snoozed issues are hidden unless it is true.
+	// If it is true, only snoozed issues are selected.
+	Snoozed bool
+	// Same as Snoozed, but for muted issues.
+	Muted bool
+
+	Starred bool
+	HasNote bool
+	// Local tags
+	Tags StringListFilter
+
+	Labels StringListFilter
+	// "owner/name"
+	Repositories StringListFilter
+	// GitHub login
+	Authors   StringListFilter
+	Assignees StringListFilter
+	// Milestone title
+	Milestones StringListFilter
+
+	// Words searched from title and body
+	Text string
+}
+
+// StringListFilter matches issues having any of Include and none of Exclude.
+// Empty lists do not filter issues.
+type StringListFilter struct {
+	Include []string
+	Exclude []string
+}
+
+type IssuesPage struct {
+	Issues     []*IssueOld
+	TotalCount int
+	// It is empty if there is no next page.
+	NextCursor string
 }
 
 func issuesIndex(c echo.Context) error {
@@ -82,27 +231,303 @@ func issuesIndex(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	// TODO: set page and per page
 	q := &SearchIssuesQuery{
-		page:      1,
-		perPage:   100,
-		channelID: channelID,
-		filter:    &SearchIssueFilter{},
+		perPage:    defaultPerPage,
+		sort:       "updated",
+		order:      "desc",
+		channelIDs: []int{channelID},
+		filter:     &SearchIssueFilter{},
+	}
+	if err := bindPagination(c, q); err != nil {
+		return err
+	}
+	if err := bindIssueFilter(c, q); err != nil {
+		return err
+	}
+
+	return respondIssuesPage(c, q)
+}
+
+type ReadModeParams struct {
+	// "global" or "channel"
+	ReadMode string
+}
+
+func channelsCreate(c echo.Context) error {
+	p := ChannelParams{Enabled: true}
+	if err := c.Bind(&p); err != nil {
+		return err
+	}
+	ch := &Channel{ReadMode: ReadModeGlobal}
+	if err := p.apply(ch); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	ctx := c.Request().Context()
+	if err := CreateChannel(ctx, ch); err != nil {
+		return unprocessableHTTPError(err)
+	}
+	RestartAccountWorkers(ch.AccountID)
+	return c.JSON(http.StatusCreated, ch)
+}
+
+func channelsUpdate(c echo.Context) error {
+	ch, err := findChannel(c)
+	if err != nil {
+		return err
+	}
+	p, err := ch.channelParams()
+	if err != nil {
+		return err
+	}
+	if err := c.Bind(&p); err != nil {
+		return err
+	}
+	// Channels cannot be moved to another account.
+	p.AccountID = ch.AccountID
+	if err := p.apply(ch); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	ctx := c.Request().Context()
+	if err := UpdateChannel(ctx, ch); err != nil {
+		return unprocessableHTTPError(err)
+	}
+	RestartAccountWorkers(ch.AccountID)
+
+	cnts, err := UnreadCountForChannels(ctx, []int{ch.ID})
+	if err != nil {
+		return err
+	}
+	if err := NotifyUnreadCounts(ctx, cnts); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ch)
+}
+
+func channelsDelete(c echo.Context) error {
+	ch, err := findChannel(c)
+	if err != nil {
+		return err
+	}
+	if err := DeleteChannel(c.Request().Context(), ch.ID); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	RestartAccountWorkers(ch.AccountID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+type ReorderChannelsParams struct {
+	ChannelIDs []int
+}
+
+func channelsReorder(c echo.Context) error {
+	p := &ReorderChannelsParams{}
+	if err := c.Bind(p); err != nil {
+		return err
+	}
+	if err := ReorderChannels(c.Request().Context(), p.ChannelIDs); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// unprocessableHTTPError responds invalid channels and changes of managed rows as 422.
+func unprocessableHTTPError(err error) error {
+	switch errors.Cause(err).(type) {
+	case *invalidChannelError, *managedError:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, errors.Cause(err).Error())
+	}
+	return err
+}
+
+func findChannel(c echo.Context) (*Channel, error) {
+	channelID, err := strconv.Atoi(c.Param("channelID"))
+	if err != nil {
+		return nil, err
+	}
+	ch := &Channel{}
+	res := gormConn.Where("id = ?", channelID).First(ch)
+	if res.RecordNotFound() {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Channel %d is not found", channelID))
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return ch, nil
+}
+
+func channelsUpdateReadMode(c echo.Context) error {
+	ctx := c.Request().Context()
+	channelID, err := strconv.Atoi(c.Param("channelID"))
+	if err != nil {
+		return err
+	}
+	params := &ReadModeParams{}
+	if err := c.Bind(params); err != nil {
+		return err
+	}
+	if params.ReadMode != ReadModeGlobal && params.ReadMode != ReadModeChannel {
+		return echo.NewHTTPError(http.StatusBadRequest, "ReadMode must be global or channel")
 	}
-	err = json.Unmarshal([]byte(c.QueryParam("filter")), q.filter)
+
+	if err := UpdateChannelReadMode(ctx, channelID, params.ReadMode); err != nil {
+		return err
+	}
+
+	cnts, err := UnreadCountForChannels(ctx, []int{channelID})
 	if err != nil {
 		return err
 	}
+	if err := NotifyUnreadCounts(ctx, cnts); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// inboxIssuesIndex responds unread issues in all channels.
+func inboxIssuesIndex(c echo.Context) error {
+	q := InboxQuery(&SearchIssueFilter{})
+	if err := bindPagination(c, q); err != nil {
+		return err
+	}
+	if err := bindIssueFilter(c, q); err != nil {
+		return err
+	}
+	// The filter is replaced by bindIssueFilter.
+	restrictToInbox(q.filter)
+
+	return respondIssuesPage(c, q)
+}
 
+func respondIssuesPage(c echo.Context, q *SearchIssuesQuery) error {
+	// The sort may be changed by the filter after the cursor is bound, so it is checked here.
+	if q.cursor != nil {
+		if err := q.cursor.match(q.sort, q.order); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
 	issues, err := SelectIssues(c.Request().Context(), q)
 	if err != nil {
 		return err
 	}
-	return c.JSON(http.StatusOK, issues)
+	cnt, err := CountIssues(c.Request().Context(), q)
+	if err != nil {
+		return err
+	}
+
+	page := &IssuesPage{Issues: issues, TotalCount: cnt}
+	if len(issues) == q.perPage {
+		page.NextCursor, err = newIssuesCursor(issues[len(issues)-1], q.sort, q.order).Encode()
+		if err != nil {
+			return err
+		}
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// bindIssueFilter reads the filter from "q" parameter as a LocalQuery, or "filter" parameter as a JSON.
+func bindIssueFilter(c echo.Context, q *SearchIssuesQuery) error {
+	query := c.QueryParam("q")
+	if query == "" {
+		filter := c.QueryParam("filter")
+		if filter == "" {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(filter), q.filter); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("filter is invalid: %s", err))
+		}
+		return nil
+	}
+	if c.QueryParam("filter") != "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q and filter cannot be specified together")
+	}
+
+	lq, err := ParseLocalQuery(query)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	q.filter = lq.Filter
+	if lq.Sort != "" {
+		q.sort, q.order = lq.Sort, lq.Order
+	}
+	return nil
+}
 
+func bindPagination(c echo.Context, q *SearchIssuesQuery) error {
+	if s := c.QueryParam("sort"); s != "" {
+		if _, ok := issueSortColumns[s]; !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("sort must be one of updated, created and comments, but got %s", s))
+		}
+		q.sort = s
+	}
+	if o := c.QueryParam("order"); o != "" {
+		if o != "asc" && o != "desc" {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("order must be asc or desc, but got %s", o))
+		}
+		q.order = o
+	}
+	if p := c.QueryParam("perPage"); p != "" {
+		perPage, err := strconv.Atoi(p)
+		if err != nil || perPage < 1 || maxPerPage < perPage {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("perPage must be between 1 and %d", maxPerPage))
+		}
+		q.perPage = perPage
+	}
+	if cur := c.QueryParam("cursor"); cur != "" {
+		cursor, err := DecodeIssuesCursor(cur)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		q.cursor = cursor
+	}
 	return nil
 }
 
+func issuesSearch(c echo.Context) error {
+	q := &FullTextSearchQuery{
+		query: c.QueryParam("q"),
+		limit: 100,
+	}
+	if cid := c.QueryParam("channelID"); cid != "" {
+		channelID, err := strconv.Atoi(cid)
+		if err != nil {
+			return err
+		}
+		q.channelID = channelID
+	}
+
+	issues, err := SearchIssuesFullText(c.Request().Context(), q)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, issues)
+}
+
+type IssueWithLinks struct {
+	*IssueOld
+	LinkedIssues []*LinkedIssue
+}
+
+func issuesShow(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+
+	issue, err := SelectIssue(c.Request().Context(), issueID)
+	if err != nil {
+		return err
+	}
+	if issue == nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Issue %d is not found", issueID))
+	}
+
+	links, err := SelectLinkedIssues(c.Request().Context(), issue)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &IssueWithLinks{IssueOld: issue, LinkedIssues: links})
+}
+
 func issuesMarkAsRead(c echo.Context) error {
 	return handleAlreadyRead(c, true)
 }
@@ -117,22 +542,476 @@ func handleAlreadyRead(c echo.Context, read bool) error {
 		return err
 	}
 
-	err = UpdateIssueAlreadyRead(c.Request().Context(), issueID, read)
+	// The read state in the channel is updated if the channel has the channel read mode.
+	// Without channelID, the issue is read in all channels including ones with the channel read mode.
+	channelID := 0
+	if cid := c.QueryParam("channelID"); cid != "" {
+		channelID, err = strconv.Atoi(cid)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "channelID must be an integer")
+		}
+	}
+
+	kind := ActionMarkAsUnread
+	if read {
+		kind = ActionMarkAsRead
+	}
+	err = RecordAction(c.Request().Context(), kind, []int{issueID}, func(tx *gorm.DB) error {
+		return UpdateIssuesAlreadyReadInChannel(c.Request().Context(), []int{issueID}, channelID, read, tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	return notifyUnreadCountForIssue(c, issueID)
+}
+
+func viewsIndex(c echo.Context) error {
+	views := make([]View, 0)
+	if err := gormConn.Find(&views).Error; err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, views)
+}
+
+func viewsCreate(c echo.Context) error {
+	v := &View{}
+	if err := c.Bind(v); err != nil {
+		return err
+	}
+	v.ID = 0
+	msg, err := validateView(c.Request().Context(), v)
+	if err != nil {
+		return err
+	}
+	if msg != "" {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msg)
+	}
+
+	if err := gormConn.Create(v).Error; err != nil {
+		return err
+	}
+	if err := notifyViewUnreadCount(c, v); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, v)
+}
+
+func viewsUpdate(c echo.Context) error {
+	v, err := findView(c)
+	if err != nil {
+		return err
+	}
+	id := v.ID
+	if err := c.Bind(v); err != nil {
+		return err
+	}
+	v.ID = id
+	msg, err := validateView(c.Request().Context(), v)
+	if err != nil {
+		return err
+	}
+	if msg != "" {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msg)
+	}
+
+	if err := gormConn.Save(v).Error; err != nil {
+		return err
+	}
+	if err := notifyViewUnreadCount(c, v); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, v)
+}
+
+func viewsDelete(c echo.Context) error {
+	v, err := findView(c)
+	if err != nil {
+		return err
+	}
+	if err := gormConn.Delete(v).Error; err != nil {
+		return err
+	}
+	// Clients clear the unread count of the deleted view.
+	unreadCountNotifier.NotifyView(&ViewUnreadCount{ViewID: v.ID})
+	return c.NoContent(http.StatusNoContent)
+}
+
+func viewIssuesIndex(c echo.Context) error {
+	v, err := findView(c)
+	if err != nil {
+		return err
+	}
+	q, err := v.SearchIssuesQuery()
+	if err != nil {
+		return err
+	}
+	if err := bindPagination(c, q); err != nil {
+		return err
+	}
+
+	return respondIssuesPage(c, q)
+}
+
+func findView(c echo.Context) (*View, error) {
+	viewID, err := strconv.Atoi(c.Param("viewID"))
+	if err != nil {
+		return nil, err
+	}
+	v := &View{}
+	res := gormConn.First(v, viewID)
+	if res.RecordNotFound() {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("View %d is not found", viewID))
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return v, nil
+}
+
+func notifyViewUnreadCount(c echo.Context, v *View) error {
+	cnt, err := v.UnreadCount(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	unreadCountNotifier.NotifyView(cnt)
+	return nil
+}
+
+func issuesStar(c echo.Context) error {
+	return handleStar(c, true)
+}
+
+func issuesUnstar(c echo.Context) error {
+	return handleStar(c, false)
+}
+
+func handleStar(c echo.Context, starred bool) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+
+	if err := StarIssue(c.Request().Context(), issueID, starred); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+type TagsParams struct {
+	Tags []string
+}
+
+func issuesUpdateTags(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+	params := &TagsParams{}
+	if err := c.Bind(params); err != nil {
+		return err
+	}
+
+	if err := SetIssueTags(c.Request().Context(), issueID, params.Tags); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func tagsIndex(c echo.Context) error {
+	tags, err := SelectTags(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tags)
+}
+
+type NoteParams struct {
+	// Markdown
+	Body string
+}
+
+func issuesUpdateNote(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+	params := &NoteParams{}
+	if err := c.Bind(params); err != nil {
+		return err
+	}
+
+	if err := SetIssueNote(c.Request().Context(), issueID, params.Body); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+type MuteParams struct {
+	// Ignore GitHub notification threads of the issue
+	Unsubscribe bool
+}
+
+type MuteResult struct {
+	// The number of unsubscribed notification threads
+	UnsubscribedThreads int
+}
+
+func issuesMute(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+	params := &MuteParams{}
+	if err := c.Bind(params); err != nil {
+		return err
+	}
+
+	err = RecordAction(c.Request().Context(), ActionMute, []int{issueID}, func(tx *gorm.DB) error {
+		return MuteIssue(c.Request().Context(), issueID, true, tx)
+	})
+	if err != nil {
+		return err
+	}
+	res := &MuteResult{}
+	if params.Unsubscribe {
+		res.UnsubscribedThreads, err = UnsubscribeIssueThread(c.Request().Context(), issueID)
+		if err != nil {
+			return err
+		}
+	}
+
+	cnts, err := UnreadCountForIssue(c.Request().Context(), []int{issueID})
+	if err != nil {
+		return err
+	}
+	if err := NotifyUnreadCounts(c.Request().Context(), cnts); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func issuesUnmute(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+
+	err = RecordAction(c.Request().Context(), ActionUnmute, []int{issueID}, func(tx *gorm.DB) error {
+		return MuteIssue(c.Request().Context(), issueID, false, tx)
+	})
+	if err != nil {
+		return err
+	}
+	return notifyUnreadCountForIssue(c, issueID)
+}
+
+type SnoozeParams struct {
+	// RFC3339
+	Until        *time.Time
+	UntilUpdated bool
+}
+
+func issuesSnooze(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+	params := &SnoozeParams{}
+	if err := c.Bind(params); err != nil {
+		return err
+	}
+	if params.Until == nil && !params.UntilUpdated {
+		return echo.NewHTTPError(http.StatusBadRequest, "Until or UntilUpdated is required")
+	}
+
+	err = RecordAction(c.Request().Context(), ActionSnooze, []int{issueID}, func(tx *gorm.DB) error {
+		return SnoozeIssue(c.Request().Context(), issueID, params.Until, params.UntilUpdated, tx)
+	})
+	if err != nil {
+		return err
+	}
+	return notifyUnreadCountForIssue(c, issueID)
+}
+
+func issuesUnsnooze(c echo.Context) error {
+	issueID, err := strconv.Atoi(c.Param("issueID"))
+	if err != nil {
+		return err
+	}
+
+	err = RecordAction(c.Request().Context(), ActionUnsnooze, []int{issueID}, func(tx *gorm.DB) error {
+		return UnsnoozeIssue(c.Request().Context(), issueID, tx)
+	})
+	if err != nil {
+		return err
+	}
+	return notifyUnreadCountForIssue(c, issueID)
+}
+
+func actionsIndex(c echo.Context) error {
+	limit := 50
+	if l := c.QueryParam("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be an integer")
+		}
+	}
+
+	actions, err := SelectActions(c.Request().Context(), limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, actions)
+}
+
+type UndoParams struct {
+	// The number of actions to undo. Defaults to 1.
+	Count int
+}
+
+type UndoResult struct {
+	IssueIDs []int
+	// Issues changed after the undone actions. They are not restored.
+	SkippedIssueIDs []int
+}
+
+func actionsUndo(c echo.Context) error {
+	ctx := c.Request().Context()
+	params := &UndoParams{}
+	// The body is optional.
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(params); err != nil {
+			return err
+		}
+	}
+	if params.Count == 0 {
+		params.Count = 1
+	}
+	if params.Count < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Count must be positive")
+	}
+
+	issueIDs, skipped, err := UndoActions(ctx, params.Count)
+	if err != nil {
+		return err
+	}
+
+	cnts, err := UnreadCountForIssue(ctx, issueIDs)
 	if err != nil {
 		return err
 	}
+	if err := NotifyUnreadCounts(ctx, cnts); err != nil {
+		return err
+	}
 
+	return c.JSON(http.StatusOK, &UndoResult{IssueIDs: issueIDs, SkippedIssueIDs: skipped})
+}
+
+func notifyUnreadCountForIssue(c echo.Context, issueID int) error {
 	cnts, err := UnreadCountForIssue(c.Request().Context(), []int{issueID})
 	if err != nil {
 		return err
 	}
-	for _, cnt := range cnts {
-		unreadCountNotifier.Notify(cnt)
+	if err := NotifyUnreadCounts(c.Request().Context(), cnts); err != nil {
+		return err
 	}
 
 	return c.NoContent(http.StatusOK)
 }
 
+// BulkAlreadyReadParams specifies issues to update.
+// Either IssueIDs, or ChannelID with optional Filter or Query is required.
+// If ChannelID has the channel read mode, the read state in the channel is updated.
+type BulkAlreadyReadParams struct {
+	IssueIDs []int
+
+	ChannelID int
+	Filter    *SearchIssueFilter
+	// LocalQuery
+	Query string
+}
+
+type BulkAlreadyReadResult struct {
+	IssueIDs []int
+}
+
+func issuesBulkMarkAsRead(c echo.Context) error {
+	return handleBulkAlreadyRead(c, true)
+}
+
+func issuesBulkMarkAsUnread(c echo.Context) error {
+	return handleBulkAlreadyRead(c, false)
+}
+
+func handleBulkAlreadyRead(c echo.Context, read bool) error {
+	ctx := c.Request().Context()
+	params := &BulkAlreadyReadParams{}
+	if err := c.Bind(params); err != nil {
+		return err
+	}
+
+	issueIDs, err := bulkTargetIssueIDs(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	kind := ActionBulkMarkAsUnread
+	if read {
+		kind = ActionBulkMarkAsRead
+	}
+	err = RecordAction(ctx, kind, issueIDs, func(tx *gorm.DB) error {
+		return UpdateIssuesAlreadyReadInChannel(ctx, issueIDs, params.ChannelID, read, tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	cnts, err := UnreadCountForIssue(ctx, issueIDs)
+	if err != nil {
+		return err
+	}
+	if err := NotifyUnreadCounts(ctx, cnts); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &BulkAlreadyReadResult{IssueIDs: issueIDs})
+}
+
+func bulkTargetIssueIDs(ctx context.Context, params *BulkAlreadyReadParams) ([]int, error) {
+	if len(params.IssueIDs) != 0 {
+		if params.Filter != nil || params.Query != "" {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "IssueIDs cannot be specified with Filter or Query")
+		}
+		return params.IssueIDs, nil
+	}
+
+	if params.ChannelID == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "IssueIDs or ChannelID is required")
+	}
+	if params.Filter != nil && params.Query != "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Filter and Query cannot be specified together")
+	}
+
+	q := &SearchIssuesQuery{
+		channelIDs: []int{params.ChannelID},
+		filter:     params.Filter,
+	}
+	if params.Query != "" {
+		lq, err := ParseLocalQuery(params.Query)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		q.filter = lq.Filter
+	}
+	if q.filter == nil {
+		q.filter = &SearchIssueFilter{Open: true, Closed: true, Merged: true}
+	}
+
+	return SelectIssueIDs(ctx, q)
+}
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: checkOriginWS,
 }
@@ -155,7 +1034,12 @@ type WsMessage struct {
 	Payload interface{}
 }
 
-const WsTypeUnreadCount = "UnreadCount"
+const (
+	WsTypeUnreadCount     = "UnreadCount"
+	WsTypeViewUnreadCount = "ViewUnreadCount"
+	// Unread count of all channels
+	WsTypeInboxUnreadCount = "InboxUnreadCount"
+)
 
 func wsHandler(c echo.Context) error {
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
@@ -187,11 +1071,27 @@ func wsHandler(c echo.Context) error {
 			return err
 		}
 	}
+	initViewCnts, err := SelectViewsUnreadCount(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	for _, initCnt := range initViewCnts {
+		if err := ws.WriteJSON(WsMessage{Type: WsTypeViewUnreadCount, Payload: initCnt}); err != nil {
+			return err
+		}
+	}
+	initInboxCnt, err := SelectInboxUnreadCount(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	if err := ws.WriteJSON(WsMessage{Type: WsTypeInboxUnreadCount, Payload: initInboxCnt}); err != nil {
+		return err
+	}
 
 	for {
 		select {
-		case count := <-ch:
-			err := ws.WriteJSON(WsMessage{Type: WsTypeUnreadCount, Payload: count})
+		case msg := <-ch:
+			err := ws.WriteJSON(msg)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,36 @@
+package main
+
+import "context"
+
+type InboxUnreadCount struct {
+	Count int
+}
+
+// InboxQuery returns a query to select unread issues in all channels.
+func InboxQuery(filter *SearchIssueFilter) *SearchIssuesQuery {
+	restrictToInbox(filter)
+	return &SearchIssuesQuery{
+		perPage: defaultPerPage,
+		sort:    "updated",
+		order:   "desc",
+		// nil means all channels
+		channelIDs: nil,
+		filter:     filter,
+	}
+}
+
+// restrictToInbox makes the filter select only unread and not snoozed issues.
+func restrictToInbox(filter *SearchIssueFilter) {
+	filter.Read = false
+	filter.Unread = true
+	filter.Snoozed = false
+}
+
+func SelectInboxUnreadCount(ctx context.Context) (*InboxUnreadCount, error) {
+	q := InboxQuery(&SearchIssueFilter{Open: true, Closed: true, Merged: true})
+	cnt, err := CountIssues(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &InboxUnreadCount{Count: cnt}, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Stars, tags and notes are local data of korat.
+// They are stored in their own tables, so importing issues from GitHub does not touch them.
+
+func StarIssue(ctx context.Context, issueID int, starred bool) error {
+	if !starred {
+		return gormConn.Exec(`delete from issue_stars where issueID = ?`, issueID).Error
+	}
+	return gormConn.Exec(`
+		insert or ignore into issue_stars
+		(issueID, createdAt)
+		values (?, ?)
+	`, issueID, fmtTime(time.Now().UTC())).Error
+}
+
+// SetIssueTags replaces tags of the issue.
+func SetIssueTags(ctx context.Context, issueID int, tags []string) error {
+	return txGorm(func(tx *gorm.DB) error {
+		err := tx.Exec(`delete from issue_tags where issueID = ?`, issueID).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			err := tx.Exec(`
+				insert or ignore into issue_tags
+				(issueID, name)
+				values (?, ?)
+			`, issueID, tag).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+}
+
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+func SelectTags(ctx context.Context) ([]*TagCount, error) {
+	res := make([]*TagCount, 0)
+	rows, err := gormConn.Raw(`
+		select name, count(*)
+		from issue_tags
+		group by name
+		order by name
+	`).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := &TagCount{}
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// SetIssueNote saves a private markdown note of the issue. An empty body deletes the note.
+func SetIssueNote(ctx context.Context, issueID int, body string) error {
+	if body == "" {
+		return gormConn.Exec(`delete from issue_notes where issueID = ?`, issueID).Error
+	}
+	return gormConn.Exec(`
+		replace into issue_notes
+		(issueID, body, updatedAt)
+		values (?, ?, ?)
+	`, issueID, body, fmtTime(time.Now().UTC())).Error
+}
+
+func includeAnnotationsToIssues(ctx context.Context, issues []*IssueOld) error {
+	issueIDs := make([]int, len(issues))
+	issueMap := make(map[int]*IssueOld, len(issues))
+	for idx, i := range issues {
+		issueIDs[idx] = i.ID
+		issueMap[i.ID] = i
+	}
+
+	var starred []int
+	err := gormConn.Table("issue_stars").Where("issueID IN (?)", issueIDs).Pluck("issueID", &starred).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, id := range starred {
+		issueMap[id].Starred = true
+	}
+
+	rows, err := gormConn.Raw(`
+		select issueID, name
+		from issue_tags
+		where issueID IN (?)
+		order by name
+	`, issueIDs).Rows()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var issueID int
+		var name string
+		if err := rows.Scan(&issueID, &name); err != nil {
+			return errors.WithStack(err)
+		}
+		issueMap[issueID].Tags = append(issueMap[issueID].Tags, name)
+	}
+
+	rows, err = gormConn.Raw(`
+		select issueID, body
+		from issue_notes
+		where issueID IN (?)
+	`, issueIDs).Rows()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var issueID int
+		var body string
+		if err := rows.Scan(&issueID, &body); err != nil {
+			return errors.WithStack(err)
+		}
+		issueMap[issueID].Note.Valid = true
+		issueMap[issueID].Note.String = body
+	}
+
+	return nil
+}
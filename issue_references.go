@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v21/github"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// e.g. https://github.com/pocke/korat-go/issues/1, https://github.com/pocke/korat-go/pull/1
+var issueURLReferenceRe = regexp.MustCompile(`https?://[^/\s]+/([\w.-]+)/([\w.-]+)/(?:issues|pull)/(\d+)\b`)
+
+// e.g. pocke/korat-go#1
+var crossRepoReferenceRe = regexp.MustCompile(`(?:^|[^\w/.-])([\w.-]+)/([\w.-]+)#(\d+)\b`)
+
+// e.g. #1
+var localReferenceRe = regexp.MustCompile(`(?:^|[^\w/&#])#(\d+)\b`)
+
+var closingKeywordRe = regexp.MustCompile(`(?i)\b(close[sd]?|fix(e[sd])?|resolve[sd]?):?\s+$`)
+
+type IssueReference struct {
+	Owner   string
+	Name    string
+	Number  int
+	Closing bool
+}
+
+// ParseIssueReferences returns references to issues and pull requests in body.
+// owner and name are the repository of the body, they are used for references like "#123".
+func ParseIssueReferences(body string, owner string, name string) []*IssueReference {
+	res := make([]*IssueReference, 0)
+	refMap := make(map[IssueReference]*IssueReference)
+
+	add := func(start int, o, n, num string) {
+		number, err := strconv.Atoi(num)
+		if err != nil {
+			return
+		}
+		key := IssueReference{Owner: o, Name: n, Number: number}
+		closing := closingKeywordRe.MatchString(body[:start])
+
+		if ref, ok := refMap[key]; ok {
+			ref.Closing = ref.Closing || closing
+			return
+		}
+		ref := &IssueReference{Owner: o, Name: n, Number: number, Closing: closing}
+		refMap[key] = ref
+		res = append(res, ref)
+	}
+
+	for _, m := range issueURLReferenceRe.FindAllStringSubmatchIndex(body, -1) {
+		add(m[0], body[m[2]:m[3]], body[m[4]:m[5]], body[m[6]:m[7]])
+	}
+	for _, m := range crossRepoReferenceRe.FindAllStringSubmatchIndex(body, -1) {
+		add(m[2], body[m[2]:m[3]], body[m[4]:m[5]], body[m[6]:m[7]])
+	}
+	for _, m := range localReferenceRe.FindAllStringSubmatchIndex(body, -1) {
+		// m[2] - 1 is the position of "#".
+		add(m[2]-1, owner, name, body[m[2]:m[3]])
+	}
+
+	return res
+}
+
+// importIssueReferences replaces references from the issue.
+// Comments are not stored in korat, so only the body is parsed.
+// Owners and names of targets are stored in lower case.
+func importIssueReferences(ctx context.Context, issue github.Issue, owner string, name string, tx *gorm.DB) error {
+	issueID := issue.GetID()
+	err := tx.Exec(`
+			delete from issue_references
+			where issueID = ?
+		`, issueID).Error
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range ParseIssueReferences(issue.GetBody(), owner, name) {
+		if ref.Owner == owner && ref.Name == name && ref.Number == issue.GetNumber() {
+			continue
+		}
+
+		err := tx.Exec(`
+				insert into issue_references
+				(issueID, targetOwner, targetName, targetNumber, closing)
+				VALUES (?, ?, ?, ?, ?)
+			`, issueID, strings.ToLower(ref.Owner), strings.ToLower(ref.Name), ref.Number, ref.Closing).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	LinkDirectionOutgoing = "outgoing"
+	LinkDirectionIncoming = "incoming"
+)
+
+type LinkedIssue struct {
+	// "outgoing" means the issue refers to the linked issue,
+	// and "incoming" means the linked issue refers to the issue.
+	Direction string
+	Closing   bool
+	RepoOwner string
+	RepoName  string
+	Number    int
+
+	// They are null if the linked issue is not stored in korat.
+	ID            NullInt64JSON
+	Title         NullStringJSON
+	State         NullStringJSON
+	IsPullRequest NullBoolJSON
+	Merged        NullBoolJSON
+}
+
+func SelectLinkedIssues(ctx context.Context, issue *IssueOld) ([]*LinkedIssue, error) {
+	res := make([]*LinkedIssue, 0)
+
+	rows, err := gormConn.Raw(`
+		select
+			coalesce(t.repoOwner, r.targetOwner), coalesce(t.repoName, r.targetName), r.targetNumber, r.closing,
+			t.id, t.title, t.state, t.isPullRequest, t.merged
+		from
+			issue_references as r
+			left join issues as t on
+				t.number = r.targetNumber AND
+				lower(t.repoOwner) = r.targetOwner AND
+				lower(t.repoName) = r.targetName
+		where
+			r.issueID = ?
+		;
+	`, issue.ID).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := &LinkedIssue{Direction: LinkDirectionOutgoing}
+		err := rows.Scan(&l.RepoOwner, &l.RepoName, &l.Number, &l.Closing, &l.ID, &l.Title, &l.State, &l.IsPullRequest, &l.Merged)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, l)
+	}
+
+	rows, err = gormConn.Raw(`
+		select
+			s.repoOwner, s.repoName, s.number, r.closing,
+			s.id, s.title, s.state, s.isPullRequest, s.merged
+		from
+			issue_references as r,
+			issues as s
+		where
+			s.id = r.issueID AND
+			r.targetOwner = ? AND
+			r.targetName = ? AND
+			r.targetNumber = ?
+		;
+	`, strings.ToLower(issue.RepoOwner), strings.ToLower(issue.RepoName), issue.Number).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := &LinkedIssue{Direction: LinkDirectionIncoming}
+		err := rows.Scan(&l.RepoOwner, &l.RepoName, &l.Number, &l.Closing, &l.ID, &l.Title, &l.State, &l.IsPullRequest, &l.Merged)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, l)
+	}
+
+	return res, nil
+}
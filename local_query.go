@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LocalQuery is a GitHub-like query to search stored issues.
+// e.g. "is:unread is:pr label:bug -author:dependabot repo:foo/bar sort:created-asc"
+//
+// Qualifiers:
+//
+//	is:open, is:closed, is:merged, is:issue, is:pr, is:read, is:unread, is:snoozed, is:muted, is:starred, has:note
+//	label:NAME, repo:OWNER/NAME, author:LOGIN, assignee:LOGIN, milestone:TITLE, tag:NAME
+//	sort:updated, sort:created, sort:comments (with optional -asc or -desc suffix)
+//
+// A qualifier prefixed with "-" excludes matched issues.
+// Multiple values of the same qualifier match any of them, and values can be separated by comma, such as "label:bug,security".
+// Words without qualifier are searched from title and body.
+type LocalQuery struct {
+	Filter *SearchIssueFilter
+	// They are empty if sort is not specified.
+	Sort  string
+	Order string
+}
+
+type LocalQueryParseError struct {
+	// 1-origin position in the query
+	Pos int
+	Msg string
+}
+
+func (e *LocalQueryParseError) Error() string {
+	return fmt.Sprintf("query parse error at %d: %s", e.Pos, e.Msg)
+}
+
+type localQueryToken struct {
+	pos     int
+	negated bool
+	key     string
+	value   string
+}
+
+func ParseLocalQuery(q string) (*LocalQuery, error) {
+	tokens, err := tokenizeLocalQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &LocalQuery{Filter: &SearchIssueFilter{}}
+	f := res.Filter
+	types := newLocalQueryEnum("issue", "pr")
+	reads := newLocalQueryEnum("read", "unread")
+	states := newLocalQueryEnum("open", "closed", "merged")
+	var texts []string
+
+	for _, t := range tokens {
+		errorf := func(format string, a ...interface{}) error {
+			return &LocalQueryParseError{Pos: t.pos, Msg: fmt.Sprintf(format, a...)}
+		}
+
+		if t.key == "" {
+			if t.negated {
+				return nil, errorf("excluding words is not supported")
+			}
+			texts = append(texts, t.value)
+			continue
+		}
+		if t.value == "" {
+			return nil, errorf("%s: requires a value", t.key)
+		}
+
+		switch t.key {
+		case "is":
+			switch {
+			case t.value == "snoozed":
+				if t.negated {
+					return nil, errorf("-is:snoozed is not allowed. Snoozed issues are hidden by default")
+				}
+				f.Snoozed = true
+			case t.value == "muted":
+				if t.negated {
+					return nil, errorf("-is:muted is not allowed. Muted issues are hidden by default")
+				}
+				f.Muted = true
+			case t.value == "starred":
+				if t.negated {
+					return nil, errorf("-is:starred is not supported")
+				}
+				f.Starred = true
+			case types.has(t.value):
+				types.add(t.value, t.negated)
+			case reads.has(t.value):
+				reads.add(t.value, t.negated)
+			case states.has(t.value):
+				states.add(t.value, t.negated)
+			default:
+				return nil, errorf("is:%s is unknown. It must be one of open, closed, merged, issue, pr, read, unread, snoozed, muted and starred", t.value)
+			}
+		case "label":
+			addLocalQueryList(&f.Labels, t)
+		case "repo":
+			for _, v := range strings.Split(t.value, ",") {
+				if strings.Count(v, "/") != 1 {
+					return nil, errorf("repo:%s must be OWNER/NAME", v)
+				}
+			}
+			addLocalQueryList(&f.Repositories, t)
+		case "author":
+			addLocalQueryList(&f.Authors, t)
+		case "assignee":
+			addLocalQueryList(&f.Assignees, t)
+		case "milestone":
+			addLocalQueryList(&f.Milestones, t)
+		case "tag":
+			addLocalQueryList(&f.Tags, t)
+		case "has":
+			if t.value != "note" {
+				return nil, errorf("has:%s is unknown. It must be note", t.value)
+			}
+			if t.negated {
+				return nil, errorf("-has:note is not supported")
+			}
+			f.HasNote = true
+		case "sort":
+			if t.negated {
+				return nil, errorf("-sort: is not allowed")
+			}
+			if res.Sort != "" {
+				return nil, errorf("sort: is specified more than once")
+			}
+			sort, order := t.value, "desc"
+			if idx := strings.LastIndex(t.value, "-"); idx != -1 {
+				sort, order = t.value[:idx], t.value[idx+1:]
+			}
+			if _, ok := issueSortColumns[sort]; !ok {
+				return nil, errorf("sort:%s is unknown. It must be one of updated, created and comments", sort)
+			}
+			if order != "asc" && order != "desc" {
+				return nil, errorf("sort order %s is unknown. It must be asc or desc", order)
+			}
+			res.Sort, res.Order = sort, order
+		default:
+			return nil, errorf("%s: is an unknown qualifier", t.key)
+		}
+	}
+
+	typeSet, err := types.result()
+	if err != nil {
+		return nil, &LocalQueryParseError{Pos: 1, Msg: "is:issue and is:pr " + err.Error()}
+	}
+	readSet, err := reads.result()
+	if err != nil {
+		return nil, &LocalQueryParseError{Pos: 1, Msg: "is:read and is:unread " + err.Error()}
+	}
+	stateSet, err := states.result()
+	if err != nil {
+		return nil, &LocalQueryParseError{Pos: 1, Msg: "is:open, is:closed and is:merged " + err.Error()}
+	}
+
+	f.Issue, f.PullRequest = typeSet["issue"], typeSet["pr"]
+	f.Read, f.Unread = readSet["read"], readSet["unread"]
+	f.Open, f.Closed, f.Merged = stateSet["open"], stateSet["closed"], stateSet["merged"]
+	f.Text = strings.Join(texts, " ")
+
+	return res, nil
+}
+
+func addLocalQueryList(l *StringListFilter, t localQueryToken) {
+	for _, v := range strings.Split(t.value, ",") {
+		if t.negated {
+			l.Exclude = append(l.Exclude, v)
+		} else {
+			l.Include = append(l.Include, v)
+		}
+	}
+}
+
+func tokenizeLocalQuery(q string) ([]localQueryToken, error) {
+	var res []localQueryToken
+	i := 0
+	for i < len(q) {
+		if q[i] == ' ' || q[i] == '\t' || q[i] == '\n' {
+			i++
+			continue
+		}
+
+		t := localQueryToken{pos: i + 1}
+		if q[i] == '-' {
+			t.negated = true
+			i++
+		}
+
+		// Read the key or a bare word
+		start := i
+		for i < len(q) && q[i] != ' ' && q[i] != '\t' && q[i] != '\n' && q[i] != ':' && q[i] != '"' {
+			i++
+		}
+		word := q[start:i]
+
+		if i < len(q) && q[i] == ':' {
+			if word == "" {
+				return nil, &LocalQueryParseError{Pos: i + 1, Msg: "qualifier name is missing"}
+			}
+			t.key = word
+			i++
+			v, next, err := readLocalQueryValue(q, i)
+			if err != nil {
+				return nil, err
+			}
+			t.value = v
+			i = next
+		} else if i < len(q) && q[i] == '"' {
+			if word != "" {
+				return nil, &LocalQueryParseError{Pos: i + 1, Msg: `unexpected "`}
+			}
+			v, next, err := readLocalQueryValue(q, i)
+			if err != nil {
+				return nil, err
+			}
+			t.value = v
+			i = next
+		} else {
+			t.value = word
+			if word == "" {
+				return nil, &LocalQueryParseError{Pos: t.pos, Msg: "- must be followed by a qualifier"}
+			}
+		}
+
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// readLocalQueryValue reads a value from q[i:], whose parts may be quoted by double quotes.
+// It returns the value and the position after the value.
+func readLocalQueryValue(q string, i int) (string, int, error) {
+	var b strings.Builder
+	for i < len(q) && q[i] != ' ' && q[i] != '\t' && q[i] != '\n' {
+		if q[i] != '"' {
+			b.WriteByte(q[i])
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(q[i+1:], '"')
+		if end == -1 {
+			return "", 0, &LocalQueryParseError{Pos: i + 1, Msg: "quote is not closed"}
+		}
+		b.WriteString(q[i+1 : i+1+end])
+		i += end + 2
+	}
+	return b.String(), i, nil
+}
+
+// localQueryEnum collects is: qualifiers in the same group, such as is:open and is:closed.
+type localQueryEnum struct {
+	values   []string
+	included map[string]bool
+	excluded map[string]bool
+}
+
+func newLocalQueryEnum(values ...string) *localQueryEnum {
+	return &localQueryEnum{
+		values:   values,
+		included: make(map[string]bool),
+		excluded: make(map[string]bool),
+	}
+}
+
+func (e *localQueryEnum) has(v string) bool {
+	for _, x := range e.values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *localQueryEnum) add(v string, negated bool) {
+	if negated {
+		e.excluded[v] = true
+	} else {
+		e.included[v] = true
+	}
+}
+
+// result returns values which issues may have.
+func (e *localQueryEnum) result() (map[string]bool, error) {
+	res := make(map[string]bool)
+	for _, v := range e.values {
+		if (len(e.included) == 0 || e.included[v]) && !e.excluded[v] {
+			res[v] = true
+		}
+	}
+	if len(res) == 0 {
+		return nil, errors.New("exclude all issues")
+	}
+	return res, nil
+}
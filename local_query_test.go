@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// allStates is the filter of a query without is: qualifiers.
+func allStates() *SearchIssueFilter {
+	return &SearchIssueFilter{Issue: true, PullRequest: true, Read: true, Unread: true, Open: true, Closed: true, Merged: true}
+}
+
+func TestParseLocalQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		filter func(f *SearchIssueFilter)
+		sort   string
+		order  string
+	}{
+		{query: "", filter: func(f *SearchIssueFilter) {}},
+		{query: "  foo \t bar\n", filter: func(f *SearchIssueFilter) { f.Text = "foo bar" }},
+
+		// Quoting
+		{query: `"foo bar" baz`, filter: func(f *SearchIssueFilter) { f.Text = "foo bar baz" }},
+		{query: `label:"help wanted"`, filter: func(f *SearchIssueFilter) { f.Labels.Include = []string{"help wanted"} }},
+		{query: `milestone:"v1 "beta`, filter: func(f *SearchIssueFilter) { f.Milestones.Include = []string{"v1 beta"} }},
+		{query: `label:""`, filter: nil},
+
+		// Lists and negation
+		{query: "label:bug,security -label:wontfix", filter: func(f *SearchIssueFilter) {
+			f.Labels.Include = []string{"bug", "security"}
+			f.Labels.Exclude = []string{"wontfix"}
+		}},
+		{query: "repo:foo/bar -author:dependabot assignee:me tag:later", filter: func(f *SearchIssueFilter) {
+			f.Repositories.Include = []string{"foo/bar"}
+			f.Authors.Exclude = []string{"dependabot"}
+			f.Assignees.Include = []string{"me"}
+			f.Tags.Include = []string{"later"}
+		}},
+		{query: "is:pr is:unread", filter: func(f *SearchIssueFilter) {
+			f.Issue = false
+			f.Read = false
+		}},
+		{query: "-is:closed -is:merged", filter: func(f *SearchIssueFilter) {
+			f.Closed = false
+			f.Merged = false
+		}},
+		{query: "is:open is:closed -is:open", filter: func(f *SearchIssueFilter) {
+			f.Open = false
+			f.Merged = false
+		}},
+		{query: "is:snoozed is:muted is:starred has:note", filter: func(f *SearchIssueFilter) {
+			f.Snoozed = true
+			f.Muted = true
+			f.Starred = true
+			f.HasNote = true
+		}},
+
+		// Sort
+		{query: "sort:created", filter: func(f *SearchIssueFilter) {}, sort: "created", order: "desc"},
+		{query: "sort:comments-asc", filter: func(f *SearchIssueFilter) {}, sort: "comments", order: "asc"},
+
+		// Errors
+		{query: "foo:bar", filter: nil},
+		{query: "is:foo", filter: nil},
+		{query: "has:label", filter: nil},
+		{query: "-foo", filter: nil},
+		{query: "-", filter: nil},
+		{query: ":bug", filter: nil},
+		{query: "label:", filter: nil},
+		{query: `label:"bug`, filter: nil},
+		{query: `foo"bar"`, filter: nil},
+		{query: "repo:foo", filter: nil},
+		{query: "is:issue is:pr -is:issue -is:pr", filter: nil},
+		{query: "-is:snoozed", filter: nil},
+		{query: "-has:note", filter: nil},
+		{query: "sort:foo", filter: nil},
+		{query: "sort:created-up", filter: nil},
+		{query: "sort:created sort:updated", filter: nil},
+		{query: "-sort:created", filter: nil},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseLocalQuery(tt.query)
+		if tt.filter == nil {
+			if err == nil {
+				t.Errorf("ParseLocalQuery(%q): expected an error, but got %+v", tt.query, q.Filter)
+			} else if _, ok := err.(*LocalQueryParseError); !ok {
+				t.Errorf("ParseLocalQuery(%q): expected LocalQueryParseError, but got %T", tt.query, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLocalQuery(%q): %v", tt.query, err)
+			continue
+		}
+
+		want := allStates()
+		tt.filter(want)
+		if !reflect.DeepEqual(q.Filter, want) {
+			t.Errorf("ParseLocalQuery(%q): got %+v, want %+v", tt.query, q.Filter, want)
+		}
+		if q.Sort != tt.sort || q.Order != tt.order {
+			t.Errorf("ParseLocalQuery(%q): got sort %s-%s, want %s-%s", tt.query, q.Sort, q.Order, tt.sort, tt.order)
+		}
+	}
+}
+
+func TestParseLocalQueryErrorPosition(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{"is:open foo:bar", 9},
+		{`label:"bug`, 7},
+		{"is:pr -", 7},
+		{"foo :bar", 5},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseLocalQuery(tt.query)
+		perr, ok := err.(*LocalQueryParseError)
+		if !ok {
+			t.Errorf("ParseLocalQuery(%q): expected LocalQueryParseError, but got %v", tt.query, err)
+			continue
+		}
+		if perr.Pos != tt.pos {
+			t.Errorf("ParseLocalQuery(%q): got position %d, want %d", tt.query, perr.Pos, tt.pos)
+		}
+	}
+}
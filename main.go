@@ -1,31 +1,29 @@
 package main
 
 import (
-	"context"
+	"flag"
 	"fmt"
 	"os"
 )
 
 func main() {
-	err := dbMigrate()
+	conf, args, err := parseConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		fmt.Print(usage)
+		os.Exit(0)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		os.Exit(2)
 	}
 
-	ctx := context.Background()
-	go func() {
-		err := StartFetchIssues(ctx)
-		if err != nil {
-			panic(err)
-		}
-	}()
-	go func() {
-		err := StartDetermineMerged(ctx)
-		if err != nil {
-			panic(err)
-		}
-	}()
-	go StartHTTPServer(5427)
-	select {}
+	err = runCommand(conf, args)
+	if uerr, ok := err.(*usageError); ok {
+		fmt.Fprintln(os.Stderr, uerr)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(1)
+	}
 }
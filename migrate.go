@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Migration is a change of the schema. See migrations for the list.
+type Migration struct {
+	ID   int
+	Name string
+	// SQL to apply the migration
+	Up string
+	// SQL to revert the migration. It is empty if the migration is irreversible.
+	Down string
+}
+
+// checksum detects edits of applied migrations, including their Down. Changes of whitespace are ignored.
+func (m Migration) checksum() string {
+	normalize := func(s string) string { return strings.Join(strings.Fields(s), " ") }
+	sum := sha256.Sum256([]byte(normalize(m.Up) + "\n" + normalize(m.Down)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) Reversible() bool {
+	return m.Down != ""
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+	// Changed is true if the migration was edited after it was applied.
+	Changed bool
+}
+
+// dbMigrate applies pending migrations. The database file is backed up before applying them.
+func dbMigrate() error {
+	_, _, err := MigrateUp(false)
+	return err
+}
+
+// MigrateUp applies pending migrations, and returns them with the path of the backup.
+// If dryRun is true, it only returns pending migrations without any changes.
+func MigrateUp(dryRun bool) ([]Migration, string, error) {
+	if err := checkFTS5(); err != nil {
+		return nil, "", err
+	}
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkMigrationStatuses(statuses); err != nil {
+		return nil, "", err
+	}
+
+	pending := make([]Migration, 0)
+	anyApplied := false
+	for _, s := range statuses {
+		if s.Applied {
+			anyApplied = true
+		} else {
+			pending = append(pending, s.Migration)
+		}
+	}
+	upToDate, err := isMigrationTableUpToDate()
+	if err != nil {
+		return nil, "", err
+	}
+	if dryRun || (len(pending) == 0 && upToDate) {
+		return pending, "", nil
+	}
+
+	backup := ""
+	// A new database has nothing to back up.
+	if anyApplied {
+		backup, err = backupDB()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if err := ensureMigrationTable(); err != nil {
+		return nil, backup, err
+	}
+	for _, m := range pending {
+		err := txGorm(func(tx *gorm.DB) error {
+			applied, err := isMigrationApplied(tx, m.ID)
+			if err != nil || applied {
+				return err
+			}
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return errors.Wrapf(err, "Migration %d (%s) failed", m.ID, m.Name)
+			}
+			err = tx.Exec(`insert into migration_info(id, checksum, appliedAt) values(?, ?, ?)`, m.ID, m.checksum(), fmtTime(time.Now())).Error
+			return errors.WithStack(err)
+		})
+		if err != nil {
+			return nil, backup, err
+		}
+	}
+	return pending, backup, nil
+}
+
+// MigrateDown reverts the last n applied migrations, and returns them with the path of the backup.
+// It fails without any changes if one of them is irreversible.
+func MigrateDown(n int, dryRun bool) ([]Migration, string, error) {
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkMigrationStatuses(statuses); err != nil {
+		return nil, "", err
+	}
+
+	targets := make([]Migration, 0, n)
+	for i := len(statuses) - 1; i >= 0 && len(targets) < n; i-- {
+		s := statuses[i]
+		if !s.Applied {
+			continue
+		}
+		if !s.Reversible() {
+			return nil, "", errors.Errorf("Migration %d (%s) is irreversible. Restore a backup of the database instead", s.ID, s.Name)
+		}
+		targets = append(targets, s.Migration)
+	}
+	if dryRun || len(targets) == 0 {
+		return targets, "", nil
+	}
+
+	backup, err := backupDB()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := ensureMigrationTable(); err != nil {
+		return nil, backup, err
+	}
+	for _, m := range targets {
+		err := txGorm(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.Down).Error; err != nil {
+				return errors.Wrapf(err, "Reverting migration %d (%s) failed", m.ID, m.Name)
+			}
+			return errors.WithStack(tx.Exec(`delete from migration_info where id = ?`, m.ID).Error)
+		})
+		if err != nil {
+			return nil, backup, err
+		}
+	}
+	return targets, backup, nil
+}
+
+// MigrationStatuses returns all migrations with their states in the database.
+// Migrations which are applied but unknown to this binary are returned with an empty Name.
+// It does not change the database, even if migration_info is missing or created by older versions.
+func MigrationStatuses() ([]MigrationStatus, error) {
+	applied, err := selectAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		if i, ok := applied[m.ID]; ok {
+			s.Applied = true
+			s.AppliedAt = i.appliedAt.String
+			// Migrations applied before checksums were recorded are trusted.
+			s.Changed = i.checksum.Valid && i.checksum.String != m.checksum()
+			delete(applied, m.ID)
+		}
+		res = append(res, s)
+	}
+	for id, i := range applied {
+		res = append(res, MigrationStatus{Migration: Migration{ID: id}, Applied: true, AppliedAt: i.appliedAt.String})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+func checkMigrationStatuses(statuses []MigrationStatus) error {
+	for _, s := range statuses {
+		if s.Name == "" {
+			return errors.Errorf("Migration %d is applied to the database, but it is unknown. The database may be migrated by a newer korat-go", s.ID)
+		}
+		if s.Changed {
+			return errors.Errorf("Migration %d (%s) was changed after it was applied. Revert the change and add a new migration instead", s.ID, s.Name)
+		}
+	}
+	return nil
+}
+
+type appliedMigration struct {
+	checksum  NullStringJSON
+	appliedAt NullStringJSON
+}
+
+// selectAppliedMigrations reads migration_info without changing it.
+// Checksums and applied times are null in the table created by older versions.
+func selectAppliedMigrations() (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+	columns, err := migrationTableColumns()
+	if err != nil || len(columns) == 0 {
+		return applied, err
+	}
+	query := `select id, checksum, appliedAt from migration_info`
+	if !columns["checksum"] {
+		query = `select id, null, null from migration_info`
+	}
+	rows, err := gormConn.Raw(query).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var i appliedMigration
+		if err := rows.Scan(&id, &i.checksum, &i.appliedAt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		applied[id] = i
+	}
+	return applied, errors.WithStack(rows.Err())
+}
+
+// migrationTableColumns returns columns of migration_info. It is empty if the table does not exist.
+func migrationTableColumns() (map[string]bool, error) {
+	columns := make(map[string]bool)
+	rows, err := gormConn.Raw(`pragma table_info(migration_info)`).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt NullStringJSON
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		columns[name] = true
+	}
+	return columns, errors.WithStack(rows.Err())
+}
+
+func isMigrationTableUpToDate() (bool, error) {
+	columns, err := migrationTableColumns()
+	return columns["checksum"], err
+}
+
+// ensureMigrationTable creates migration_info, and adds columns to the table created by older versions.
+// Checksums of migrations applied by older versions are recorded here.
+// It is called after the database is backed up.
+func ensureMigrationTable() error {
+	err := gormConn.Exec(`create table if not exists migration_info (
+		id integer not null primary key
+	)`).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	upToDate, err := isMigrationTableUpToDate()
+	if err != nil || upToDate {
+		return err
+	}
+
+	return txGorm(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			alter table migration_info add column checksum string;
+			alter table migration_info add column appliedAt string;
+		`).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, m := range migrations {
+			err := tx.Exec(`update migration_info set checksum = ? where id = ?`, m.checksum(), m.ID).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+}
+
+func isMigrationApplied(tx *gorm.DB, id int) (bool, error) {
+	var cnt int
+	err := tx.Raw(`select count(*) from migration_info where id = ?`, id).Row().Scan(&cnt)
+	return cnt != 0, errors.WithStack(err)
+}
+
+// backupDB copies the database next to it with the online backup API, and returns the path of the copy.
+// The copy is consistent even if other connections write to the database meanwhile.
+func backupDB() (string, error) {
+	base := fmt.Sprintf("%s.%s", dbPath, time.Now().Format("20060102150405"))
+	dst := base + ".bak"
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	// Backed up twice in a second
+	for i := 1; os.IsExist(err); i++ {
+		dst = fmt.Sprintf("%s-%d.bak", base, i)
+		f, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	dstDB, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer dstDB.Close()
+
+	// The backup API requires the driver connections of both databases.
+	ctx := context.Background()
+	srcConn, err := gormConn.DB().Conn(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer srcConn.Close()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "Cannot open database %s", dst)
+	}
+	defer dstConn.Close()
+
+	err = srcConn.Raw(func(src interface{}) error {
+		return dstConn.Raw(func(dst interface{}) error {
+			b, err := dst.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "Cannot back up the database to %s", dst)
+	}
+	return dst, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func selectTestAppliedMigrationIDs(t *testing.T) []int {
+	t.Helper()
+	var ids []int
+	if err := gormConn.Raw(`select id from migration_info order by id`).Pluck("id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func backupFiles(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob(dbPath + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMigrateChangedMigration(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `update migration_info set checksum = 'changed' where id = 1`)
+
+	if _, _, err := MigrateUp(false); err == nil {
+		t.Error("migrated up with a changed migration")
+	}
+	if _, _, err := MigrateDown(1, false); err == nil {
+		t.Error("migrated down with a changed migration")
+	}
+	if files := backupFiles(t); len(files) != 0 {
+		t.Errorf("backed up to %v", files)
+	}
+}
+
+func TestMigrateUnknownMigration(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, `insert into migration_info (id, checksum, appliedAt) values (9999, '', '')`)
+
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; last.ID != 9999 || !last.Applied || last.Name != "" {
+		t.Errorf("got %+v, want the unknown migration 9999", last)
+	}
+	if _, _, err := MigrateUp(false); err == nil {
+		t.Error("migrated up with an unknown migration")
+	}
+	if _, _, err := MigrateDown(1, false); err == nil {
+		t.Error("migrated down with an unknown migration")
+	}
+}
+
+func TestMigrateDownIrreversible(t *testing.T) {
+	openTestDB(t)
+	before := selectTestAppliedMigrationIDs(t)
+
+	// Migration 23 is irreversible.
+	if _, _, err := MigrateDown(len(migrations)-22, false); err == nil {
+		t.Fatal("reverted an irreversible migration")
+	}
+	if got := selectTestAppliedMigrationIDs(t); !reflect.DeepEqual(got, before) {
+		t.Errorf("applied migrations are changed to %v", got)
+	}
+	if files := backupFiles(t); len(files) != 0 {
+		t.Errorf("backed up to %v", files)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	openTestDB(t)
+	last := migrations[len(migrations)-1]
+
+	targets, backup, err := MigrateDown(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].ID != last.ID || backup != "" {
+		t.Errorf("got %v and %q, want migration %d without a backup", targets, backup, last.ID)
+	}
+	if ids := selectTestAppliedMigrationIDs(t); ids[len(ids)-1] != last.ID {
+		t.Errorf("migration %d is reverted by the dry run", last.ID)
+	}
+
+	if _, _, err := MigrateDown(1, false); err != nil {
+		t.Fatal(err)
+	}
+	applied := selectTestAppliedMigrationIDs(t)
+	backups := backupFiles(t)
+	pending, backup, err := MigrateUp(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != last.ID || backup != "" {
+		t.Errorf("got %v and %q, want migration %d without a backup", pending, backup, last.ID)
+	}
+	if got := selectTestAppliedMigrationIDs(t); !reflect.DeepEqual(got, applied) {
+		t.Errorf("applied migrations are changed to %v by the dry run", got)
+	}
+	if got := backupFiles(t); !reflect.DeepEqual(got, backups) {
+		t.Errorf("backed up to %v by the dry run", got)
+	}
+}
+
+func TestBackupDB(t *testing.T) {
+	openTestDB(t)
+	insertTestIssues(t, 3)
+
+	backup, err := backupDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var cnt int
+	if err := db.QueryRow(`select count(*) from issues`).Scan(&cnt); err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 3 {
+		t.Errorf("the backup has %d issues, want 3", cnt)
+	}
+}
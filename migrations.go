@@ -0,0 +1,483 @@
+package main
+
+// migrations are applied in this order. Never edit an applied migration, add a new one instead.
+// Down is empty if the migration cannot be reverted.
+// SQLite bundled with go-sqlite3 cannot drop columns, so migrations adding columns are irreversible.
+var migrations = []Migration{
+	{
+		ID:   1,
+		Name: "create accounts, channels and issues",
+		Up: `
+			create table accounts (
+				id          integer not null primary key,
+				displayName string not null,
+				urlBase     string not null,
+				apiUrlBase  string not null,
+				accessToken string not null
+			);
+
+			create table channels (
+				id            integer not null primary key,
+				displayName   string not null,
+				system        string,
+				queries       string not null,
+
+				accountID     integer not null,
+
+				FOREIGN KEY(accountID) REFERENCES accounts(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+
+			create table github_users (
+				id          integer not null primary key,
+				login       string not null,
+				avatarURL   string not null
+			);
+
+			create table issues (
+				id            integer not null primary key,
+				number        integer not null,
+				title         string not null,
+				userID        integer not null,
+				repoOwner     string not null,
+				repoName      string not null,
+				state         string not null,
+				locked        bool not null,
+				comments      integer not null,
+				createdAt     string not null,
+				updatedAt     string not null,
+				closedAt      string,
+				isPullRequest boolean not null,
+				body          string not null,
+				alreadyRead   boolean not null,
+				milestoneID   integer,
+
+				FOREIGN KEY(userID) REFERENCES github_users(id) ON UPDATE CASCADE ON DELETE CASCADE
+				FOREIGN KEY(milestoneID) REFERENCES milestones(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+
+			create table labels (
+				id          integer not null primary key,
+				name        string not null,
+				color       string not null,
+				'default'   boolean not null
+			);
+
+			create table milestones (
+				id            integer not null primary key,
+				number        integer not null,
+				title         string not null,
+				description   string not null,
+				state         string not null,
+				createdAt     string not null,
+				updatedAt     string not null,
+				closedAt      string
+			);
+
+			create table assigned_labels_to_issue (
+				id          integer not null primary key,
+				issueID     integer not null,
+				labelID     integer not null,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+				FOREIGN KEY(labelID) REFERENCES labels(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create unique index uniq_issue_label on assigned_labels_to_issue(issueID, labelID);
+
+			create table assigned_users_to_issue (
+				id          integer not null primary key,
+				issueID     integer not null,
+				userID      integer not null,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+				FOREIGN KEY(userID) REFERENCES github_users(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create unique index uniq_assigned_user_to_issue on assigned_users_to_issue(issueID, userID);
+		`,
+		Down: `
+			drop table assigned_users_to_issue;
+			drop table assigned_labels_to_issue;
+			drop table milestones;
+			drop table labels;
+			drop table issues;
+			drop table github_users;
+			drop table channels;
+			drop table accounts;
+		`,
+	},
+	{
+		ID:   2,
+		Name: "create queries and channel_issues",
+		Up: `
+			create table queries (
+				id            integer not null primary key,
+				query         string not null
+			);
+
+			create table channel_issues (
+				id            integer not null primary key,
+				issueID       integer not null,
+				channelID     integer not null,
+				queryID       integer not null,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+				FOREIGN KEY(channelID) REFERENCES channels(id) ON UPDATE CASCADE ON DELETE CASCADE
+				FOREIGN KEY(queryID) REFERENCES queries(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create unique index uniq_channel_issue on channel_issues(issueID, channelID, queryID);
+		`,
+		Down: `
+			drop table channel_issues;
+			drop table queries;
+		`,
+	},
+	{
+		ID:   3,
+		Name: "add foreign key indexes",
+		Up: `
+			create index fk_channel_account_id on channels(accountID);
+			create index fk_issue_user_id on issues(userID);
+			create index fk_issue_milestone_id on issues(milestoneID);
+		`,
+		Down: `
+			drop index fk_channel_account_id;
+			drop index fk_issue_user_id;
+			drop index fk_issue_milestone_id;
+		`,
+	},
+	{
+		// Change order of index
+		ID:   4,
+		Name: "change the order of uniq_channel_issue",
+		Up: `
+			drop index uniq_channel_issue;
+			create unique index uniq_channel_issue on channel_issues(channelID, issueID, queryID);
+		`,
+		Down: `
+			drop index uniq_channel_issue;
+			create unique index uniq_channel_issue on channel_issues(issueID, channelID, queryID);
+		`,
+	},
+	{
+		ID:   5,
+		Name: "add issues.merged",
+		Up: `
+			alter table issues add column merged boolean;
+		`,
+	},
+	{
+		// changed_files is a cache of pull request files.
+		// It does not refer to issues because files are fetched before importing the pull request.
+		ID:   6,
+		Name: "create changed_files",
+		Up: `
+			create table changed_files (
+				id            integer not null primary key,
+				issueID       integer not null,
+				filename      string not null
+			);
+			create unique index uniq_changed_file on changed_files(issueID, filename);
+
+			create table changed_files_status (
+				issueID       integer not null primary key,
+				updatedAt     string not null
+			);
+		`,
+		Down: `
+			drop table changed_files_status;
+			drop table changed_files;
+		`,
+	},
+	{
+		// The target of a reference may not be stored in korat,
+		// so issue_references refers to the target by repository and number.
+		ID:   7,
+		Name: "create issue_references",
+		Up: `
+			create table issue_references (
+				id            integer not null primary key,
+				issueID       integer not null,
+				targetOwner   string not null collate nocase,
+				targetName    string not null collate nocase,
+				targetNumber  integer not null,
+				closing       boolean not null,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create unique index uniq_issue_reference on issue_references(issueID, targetOwner, targetName, targetNumber);
+			create index idx_issue_reference_target on issue_references(targetOwner, targetName, targetNumber);
+		`,
+		Down: `
+			drop table issue_references;
+		`,
+	},
+	{
+		// issues_fts is an external content table, it is kept in sync with issues by the triggers.
+		ID:   8,
+		Name: "create issues_fts",
+		Up: `
+			create virtual table issues_fts using fts5(
+				title,
+				body,
+				content='issues',
+				content_rowid='id'
+			);
+
+			create trigger issues_fts_after_insert after insert on issues begin
+				insert into issues_fts(rowid, title, body) values (new.id, new.title, new.body);
+			end;
+			create trigger issues_fts_after_delete after delete on issues begin
+				insert into issues_fts(issues_fts, rowid, title, body) values ('delete', old.id, old.title, old.body);
+			end;
+			create trigger issues_fts_after_update after update of title, body on issues begin
+				insert into issues_fts(issues_fts, rowid, title, body) values ('delete', old.id, old.title, old.body);
+				insert into issues_fts(rowid, title, body) values (new.id, new.title, new.body);
+			end;
+
+			insert into issues_fts(issues_fts) values ('rebuild');
+		`,
+		Down: `
+			drop trigger issues_fts_after_update;
+			drop trigger issues_fts_after_delete;
+			drop trigger issues_fts_after_insert;
+			drop table issues_fts;
+		`,
+	},
+	{
+		ID:   9,
+		Name: "create views",
+		Up: `
+			create table views (
+				id            integer not null primary key,
+				displayName   string not null,
+				channelIDs    string not null,
+				query         string not null,
+
+				accountID     integer not null,
+
+				FOREIGN KEY(accountID) REFERENCES accounts(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create index fk_view_account_id on views(accountID);
+		`,
+		Down: `
+			drop table views;
+		`,
+	},
+	{
+		ID:   10,
+		Name: "add channels.sourceChannelIDs",
+		Up: `
+			alter table channels add column sourceChannelIDs string;
+		`,
+	},
+	{
+		// A snoozed issue wakes up at "until", or when its updatedAt is changed from "updatedAt".
+		ID:   11,
+		Name: "create snoozes",
+		Up: `
+			create table snoozes (
+				issueID       integer not null primary key,
+				until         string,
+				updatedAt     string,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create index idx_snooze_until on snoozes(until);
+		`,
+		Down: `
+			drop table snoozes;
+		`,
+	},
+	{
+		ID:   12,
+		Name: "add issues.muted",
+		Up: `
+			alter table issues add column muted boolean not null default 0;
+		`,
+	},
+	{
+		ID:   13,
+		Name: "create issue_stars, issue_tags and issue_notes",
+		Up: `
+			create table issue_stars (
+				issueID       integer not null primary key,
+				createdAt     string not null,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+
+			create table issue_tags (
+				id            integer not null primary key,
+				issueID       integer not null,
+				name          string not null collate nocase,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create unique index uniq_issue_tag on issue_tags(issueID, name);
+			create index idx_issue_tag_name on issue_tags(name);
+
+			create table issue_notes (
+				issueID       integer not null primary key,
+				body          string not null,
+				updatedAt     string not null,
+
+				FOREIGN KEY(issueID) REFERENCES issues(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+		`,
+		Down: `
+			drop table issue_notes;
+			drop table issue_tags;
+			drop table issue_stars;
+		`,
+	},
+	{
+		ID:   14,
+		Name: "create action_logs",
+		Up: `
+			create table action_logs (
+				id             integer not null primary key,
+				kind           string not null,
+				createdAt      string not null,
+				undoneActionID integer,
+
+				FOREIGN KEY(undoneActionID) REFERENCES action_logs(id)
+			);
+			create index idx_action_logs_undone on action_logs(undoneActionID);
+
+			create table action_log_entries (
+				id            integer not null primary key,
+				actionID      integer not null,
+				issueID       integer not null,
+				before        string not null,
+				after         string not null,
+
+				FOREIGN KEY(actionID) REFERENCES action_logs(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);
+			create index idx_action_log_entries_action on action_log_entries(actionID);
+		`,
+		Down: `
+			drop table action_log_entries;
+			drop table action_logs;
+		`,
+	},
+	{
+		ID:   15,
+		Name: "add read mode of channels",
+		Up: `
+			alter table channels add column readMode string not null default 'global';
+			alter table channel_issues add column alreadyRead boolean not null default 0;
+			update channel_issues set alreadyRead = (select i.alreadyRead from issues as i where i.id = channel_issues.issueID);
+		`,
+	},
+	{
+		ID:   16,
+		Name: "add excluded repositories and managed flags",
+		Up: `
+			alter table channels add column excludedRepositories string;
+			alter table channels add column managed boolean not null default 0;
+			alter table accounts add column managed boolean not null default 0;
+		`,
+	},
+	{
+		ID:   17,
+		Name: "add token sources of accounts",
+		Up: `
+			alter table accounts add column tokenEnv string;
+			alter table accounts add column tokenCommand string;
+		`,
+	},
+	{
+		ID:   18,
+		Name: "create settings",
+		Up: `
+			create table settings (
+				name          string not null primary key,
+				value         string not null
+			);
+		`,
+		Down: `
+			drop table settings;
+		`,
+	},
+	{
+		ID:   19,
+		Name: "encrypt stored access tokens",
+		// Stored tokens are encrypted by initTokenCipher on every start, including tokens saved by older versions.
+		// The migration is kept, because databases record it as applied.
+		Up: `select 1;`,
+	},
+	{
+		ID:   20,
+		Name: "add GitHub App of accounts",
+		Up: `
+			alter table accounts add column appID integer;
+			alter table accounts add column appInstallationID integer;
+			alter table accounts add column appPrivateKeyFile string;
+		`,
+	},
+	{
+		ID:   21,
+		Name: "add accounts.login",
+		Up: `
+			alter table accounts add column login string;
+		`,
+	},
+	{
+		ID:   22,
+		Name: "add position and enabled of channels",
+		Up: `
+			alter table channels add column position integer not null default 0;
+			alter table channels add column enabled boolean not null default 1;
+			update channels set position = id;
+		`,
+	},
+	{
+		ID:   23,
+		Name: "normalize times to UTC",
+		// Times are compared as strings, so they must be in the same time zone.
+		Up: `
+			update issues set createdAt = strftime('%Y-%m-%dT%H:%M:%SZ', createdAt) where createdAt not like '%Z';
+			update issues set updatedAt = strftime('%Y-%m-%dT%H:%M:%SZ', updatedAt) where updatedAt not like '%Z';
+			update issues set closedAt = strftime('%Y-%m-%dT%H:%M:%SZ', closedAt) where closedAt not like '%Z';
+			update milestones set createdAt = strftime('%Y-%m-%dT%H:%M:%SZ', createdAt) where createdAt not like '%Z';
+			update milestones set updatedAt = strftime('%Y-%m-%dT%H:%M:%SZ', updatedAt) where updatedAt not like '%Z';
+			update milestones set closedAt = strftime('%Y-%m-%dT%H:%M:%SZ', closedAt) where closedAt not like '%Z';
+			update snoozes set until = strftime('%Y-%m-%dT%H:%M:%SZ', until) where until not like '%Z';
+			update snoozes set updatedAt = strftime('%Y-%m-%dT%H:%M:%SZ', updatedAt) where updatedAt not like '%Z';
+			update issue_stars set createdAt = strftime('%Y-%m-%dT%H:%M:%SZ', createdAt) where createdAt not like '%Z';
+			update issue_notes set updatedAt = strftime('%Y-%m-%dT%H:%M:%SZ', updatedAt) where updatedAt not like '%Z';
+			update action_logs set createdAt = strftime('%Y-%m-%dT%H:%M:%SZ', createdAt) where createdAt not like '%Z';
+			update changed_files_status set updatedAt = strftime('%Y-%m-%dT%H:%M:%SZ', updatedAt) where updatedAt not like '%Z';
+		`,
+	},
+	{
+		ID:   24,
+		Name: "add indexes for issue times",
+		// For sorting issues in channels, and EdgeIssueTime.
+		// idx_channel_issue_issue covers lookups of channels and read states by issues.
+		Up: `
+			create index idx_issue_updated_at on issues(updatedAt, id);
+			create index idx_issue_created_at on issues(createdAt, id);
+			create index idx_channel_issue_query on channel_issues(queryID, issueID);
+			create index idx_channel_issue_issue on channel_issues(issueID, channelID, alreadyRead);
+		`,
+		Down: `
+			drop index idx_issue_updated_at;
+			drop index idx_issue_created_at;
+			drop index idx_channel_issue_query;
+			drop index idx_channel_issue_issue;
+		`,
+	},
+	{
+		ID:   25,
+		Name: "lower-case targets of issue_references",
+		// Targets are compared with lower(repoOwner) and lower(repoName) of issues,
+		// so the lookups of targets use idx_issue_reference_target without collate.
+		Up: `
+			update issue_references set targetOwner = lower(targetOwner), targetName = lower(targetName);
+			create index idx_issue_number on issues(number);
+		`,
+		Down: `
+			drop index idx_issue_number;
+		`,
+	},
+}
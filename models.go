@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/v21/github"
@@ -35,7 +36,7 @@ type ChannelOld struct {
 func SelectChannelsUnreadCount(ctx context.Context) ([]*UnreadCount, error) {
 	res := make([]*UnreadCount, 0)
 
-	rows, err := gormConn.Raw(`
+	rows, err := gormConn.Raw(fmt.Sprintf(`
 		select
 			X.channelID, count(X.issueID)
 		from
@@ -44,14 +45,17 @@ func SelectChannelsUnreadCount(ctx context.Context) ([]*UnreadCount, error) {
 					channelID, issueID
 				from
 					channel_issues as ci,
+					channels as c,
 					issues as i
 				where
 					ci.issueID = i.id AND
-					i.alreadyRead = 0
+					ci.channelID = c.id AND
+					%s AND
+					i.id NOT IN (select issueID from snoozes)
 			) as X
 		group by
 			X.channelID
-	`).Rows()
+	`, unreadCondition)).Rows()
 	if err != nil {
 		return nil, err
 	}
@@ -65,24 +69,60 @@ func SelectChannelsUnreadCount(ctx context.Context) ([]*UnreadCount, error) {
 		res = append(res, c)
 	}
 
+	virtualCnts, err := VirtualChannelsUnreadCount(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, virtualCnts...)
+
 	return res, nil
 }
 
+// SQLite limits the number of host parameters in a statement (999 by default),
+// so large IN clauses are split into chunks of this size.
+const sqlInChunkSize = 500
+
+func chunkInts(ids []int) [][]int {
+	res := make([][]int, 0, len(ids)/sqlInChunkSize+1)
+	for len(ids) > sqlInChunkSize {
+		res = append(res, ids[:sqlInChunkSize])
+		ids = ids[sqlInChunkSize:]
+	}
+	if len(ids) != 0 {
+		res = append(res, ids)
+	}
+	return res
+}
+
 func UnreadCountForIssue(ctx context.Context, issueIDs []int) ([]*UnreadCount, error) {
-	issueIDsStr := make([]string, len(issueIDs))
-	for idx, issueID := range issueIDs {
-		issueIDsStr[idx] = strconv.Itoa(issueID)
+	channelIDSet := make(map[int]bool)
+	for _, chunk := range chunkInts(issueIDs) {
+		var cids []int
+		err := gormConn.Table("channel_issues").
+			Where("issueID IN (?)", chunk).
+			Pluck("distinct(channelID)", &cids).Error
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, cid := range cids {
+			channelIDSet[cid] = true
+		}
 	}
 
-	var channelIDs []int
-	err := gormConn.Table("channel_issues").
-		Where("issueID IN (?)", issueIDsStr).
-		Pluck("distinct(channelID)", &channelIDs).Error
-	if err != nil {
-		return nil, errors.WithStack(err)
+	channelIDs := make([]int, 0, len(channelIDSet))
+	for cid := range channelIDSet {
+		channelIDs = append(channelIDs, cid)
 	}
+	return UnreadCountForChannels(ctx, channelIDs)
+}
 
+// UnreadCountForChannels returns unread counts of the channels and virtual channels composed from them.
+func UnreadCountForChannels(ctx context.Context, channelIDs []int) ([]*UnreadCount, error) {
 	res := make([]*UnreadCount, 0)
+	// VirtualChannelsUnreadCount treats nil as all channels, but no channels are affected here.
+	if len(channelIDs) == 0 {
+		return res, nil
+	}
 	channelMap := make(map[int]*UnreadCount, 0)
 
 	for _, cid := range channelIDs {
@@ -91,17 +131,20 @@ func UnreadCountForIssue(ctx context.Context, issueIDs []int) ([]*UnreadCount, e
 		channelMap[c.ChannelID] = c
 	}
 
-	subq := gormConn.
-		Table("channel_issues").
-		Joins("JOIN issues on issues.id = channel_issues.issueID").
-		Select("distinct channelID, issueID").
-		Where("channelID IN (?)", channelIDs).
-		Where("alreadyRead = 0").QueryExpr()
-	rows, err := gormConn.Raw(`
-		select channelID, count(issueID)
-		from (?)
-		group by channelID
-		`, subq).Rows()
+	rows, err := gormConn.Raw(fmt.Sprintf(`
+		select X.channelID, count(X.issueID)
+		from (
+			select distinct ci.channelID, ci.issueID
+			from channel_issues as ci, channels as c, issues as i
+			where
+				ci.issueID = i.id AND
+				ci.channelID = c.id AND
+				ci.channelID IN (?) AND
+				%s AND
+				i.id NOT IN (select issueID from snoozes)
+		) as X
+		group by X.channelID
+		`, unreadCondition), channelIDs).Rows()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -118,6 +161,12 @@ func UnreadCountForIssue(ctx context.Context, issueIDs []int) ([]*UnreadCount, e
 		channelMap[channelID].Count = cnt
 	}
 
+	virtualCnts, err := VirtualChannelsUnreadCount(ctx, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, virtualCnts...)
+
 	return res, nil
 }
 
@@ -143,6 +192,17 @@ func (n NullBoolJSON) MarshalJSON() ([]byte, error) {
 	return []byte("null"), nil
 }
 
+type NullInt64JSON struct {
+	sql.NullInt64
+}
+
+func (n NullInt64JSON) MarshalJSON() ([]byte, error) {
+	if n.Valid {
+		return json.Marshal(n.Int64)
+	}
+	return []byte("null"), nil
+}
+
 type IssueOld struct {
 	ID            int
 	Number        int
@@ -159,10 +219,20 @@ type IssueOld struct {
 	Body          string
 	AlreadyRead   bool
 	Merged        NullBoolJSON
+	Muted         bool
 
 	User      *UserOld
 	Labels    []*LabelOld
 	Assignees []*UserOld
+	// Channels having the issue, except virtual channels
+	ChannelIDs []int
+	// nil if the issue is not snoozed
+	Snooze *Snooze
+
+	// Local data which are not synced with GitHub
+	Starred bool
+	Tags    []string
+	Note    NullStringJSON
 }
 
 type LabelOld struct {
@@ -178,63 +248,242 @@ type UserOld struct {
 	AvatarURL string
 }
 
+const selectIssueColumns = `
+	i.id, i.number, i.title, i.repoOwner, i.repoName, i.state, i.locked, i.comments, i.createdAt, i.updatedAt, i.closedAt, i.isPullREquest, i.body, i.alreadyRead, i.merged, i.muted,
+	u.id, u.login, u.avatarURL
+`
+
 func SelectIssues(ctx context.Context, q *SearchIssuesQuery) ([]*IssueOld, error) {
-	res := make([]*IssueOld, 0)
-	additionalConds := buildFilterForSelectIssues(q.filter)
+	channelIssues, args, err := ChannelIssuesQuery(ctx, q.channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	membership, args, err := channelIssuesCond(q, channelIssues, args)
+	if err != nil {
+		return nil, err
+	}
+	readCol, err := alreadyReadColumn(ctx, q.channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	additionalConds, filterArgs := buildFilterForSelectIssues(q.filter, readCol)
+	col := issueSortColumns[q.sort]
+	args = append(args, filterArgs...)
+
+	cursorCond := ""
+	if q.cursor != nil {
+		op := "<"
+		if q.order == "asc" {
+			op = ">"
+		}
+		cursorCond = fmt.Sprintf("AND (%[1]s %[2]s ? OR (%[1]s = ? AND i.id %[2]s ?))", col, op)
+		args = append(args, q.cursor.Value, q.cursor.Value, q.cursor.ID)
+	}
+	args = append(args, q.perPage)
 
 	rows, err := gormConn.Raw(fmt.Sprintf(`
-		select distinct
-			i.id, i.number, i.title, i.repoOwner, i.repoName, i.state, i.locked, i.comments, i.createdAt, i.updatedAt, i.closedAt, i.isPullREquest, i.body, i.alreadyRead, i.merged,
-			u.id, u.login, u.avatarURL
+		select
+			%s
 		from
 			issues as i,
-			channel_issues as ci,
 			github_users as u
 		where
-			i.id = ci.issueID AND
 			u.id = i.userID AND
-			ci.channelID = ?
+			%s
+			%s
 			%s
 		order by
-			i.updatedAt desc
+			%s %s, i.id %s
 		limit
 			?
-		offset
-			?
 		;
-	`, additionalConds), q.channelID, q.perPage, (q.page-1)*q.perPage).Rows()
+	`, selectIssueColumns, membership, additionalConds, cursorCond, col, q.order, q.order), args...).Rows()
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	res, err := scanIssues(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := includeRelationsToIssues(ctx, res); err != nil {
+		return nil, err
+	}
+	if err := includeChannelReadStateToIssues(ctx, res, q.channelIDs); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// timeIndexMinIssues is the number of issues from which SelectIssues scans the index of the sort column.
+// Fewer issues are faster to look up by the ID and sort.
+const timeIndexMinIssues = 5000
+
+// channelSizeCache caches the number of issues in channels for channelIssuesCond.
+// The number only selects the query plan, so it can be a little stale.
+var channelSizeCache = struct {
+	mu      sync.Mutex
+	entries map[string]channelSize
+}{entries: make(map[string]channelSize)}
+
+const channelSizeCacheTTL = time.Minute
+
+type channelSize struct {
+	count     int
+	countedAt time.Time
+}
+
+// countChannelIssues returns the number of issues in the channels, which are selected by channelIssues.
+func countChannelIssues(channelIDs []int, channelIssues string, args []interface{}) (int, error) {
+	key := fmt.Sprint(channelIDs)
+	channelSizeCache.mu.Lock()
+	s, ok := channelSizeCache.entries[key]
+	channelSizeCache.mu.Unlock()
+	if ok && time.Since(s.countedAt) < channelSizeCacheTTL {
+		return s.count, nil
+	}
+
+	var cnt int
+	err := gormConn.Raw(fmt.Sprintf(`select count(*) from (%s)`, channelIssues), args...).Row().Scan(&cnt)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	channelSizeCache.mu.Lock()
+	defer channelSizeCache.mu.Unlock()
+	channelSizeCache.entries[key] = channelSize{count: cnt, countedAt: time.Now()}
+	return cnt, nil
+}
+
+// channelIssuesCond returns the condition that issues are in the channels of q, with its arguments.
+// SQLite looks up issues in the channels by the ID and sorts them, even if most issues are in the channels,
+// so the condition is changed to scan the sort index when a page is found early in the scan.
+func channelIssuesCond(q *SearchIssuesQuery, channelIssues string, args []interface{}) (string, []interface{}, error) {
+	lookup := fmt.Sprintf("i.id IN (%s)", channelIssues)
+	if q.sort != "updated" && q.sort != "created" {
+		return lookup, args, nil
+	}
+	// Almost all issues are in any channel.
+	if q.channelIDs == nil {
+		return "exists (select 1 from channel_issues as ci where ci.issueID = i.id)", nil, nil
+	}
+
+	cnt, err := countChannelIssues(q.channelIDs, channelIssues, args)
+	if err != nil {
+		return "", nil, err
+	}
+	if cnt < timeIndexMinIssues {
+		return lookup, args, nil
+	}
+	virtual, err := hasVirtualChannel(q.channelIDs)
+	if err != nil {
+		return "", nil, err
+	}
+	if !virtual {
+		return "exists (select 1 from channel_issues as ci where ci.issueID = i.id AND ci.channelID IN (?))", []interface{}{q.channelIDs}, nil
+	}
+	// The unary + prevents SQLite from using the ID for the lookup.
+	return "+" + lookup, args, nil
+}
+
+// CountIssues returns the number of issues matching q regardless of the pagination.
+func CountIssues(ctx context.Context, q *SearchIssuesQuery) (int, error) {
+	channelIssues, args, err := ChannelIssuesQuery(ctx, q.channelIDs)
+	if err != nil {
+		return 0, err
+	}
+	readCol, err := alreadyReadColumn(ctx, q.channelIDs)
+	if err != nil {
+		return 0, err
+	}
+	additionalConds, filterArgs := buildFilterForSelectIssues(q.filter, readCol)
+	args = append(args, filterArgs...)
+	var cnt int
+	err = gormConn.Raw(fmt.Sprintf(`
+		select
+			count(*)
+		from
+			issues as i
+		where
+			i.id IN (%s)
+			%s
+	`, channelIssues, additionalConds), args...).Row().Scan(&cnt)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return cnt, nil
+}
+
+// SelectIssue returns nil if the issue does not exist.
+func SelectIssue(ctx context.Context, issueID int) (*IssueOld, error) {
+	rows, err := gormConn.Raw(fmt.Sprintf(`
+		select
+			%s
+		from
+			issues as i,
+			github_users as u
+		where
+			u.id = i.userID AND
+			i.id = ?
+		;
+	`, selectIssueColumns), issueID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res, err := scanIssues(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+
+	if err := includeRelationsToIssues(ctx, res); err != nil {
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+func scanIssues(rows *sql.Rows) ([]*IssueOld, error) {
+	res := make([]*IssueOld, 0)
 	for rows.Next() {
-		u := &UserOld{}
-		i := &IssueOld{
-			Labels:    []*LabelOld{},
-			Assignees: []*UserOld{},
-			User:      u,
-		}
-		err := rows.Scan(&i.ID, &i.Number, &i.Title, &i.RepoOwner, &i.RepoName, &i.State, &i.Locked, &i.Comments, &i.CreatedAt, &i.UpdatedAt, &i.ClosedAt, &i.IsPullRequest, &i.Body, &i.AlreadyRead, &i.Merged,
-			&u.ID, &u.Login, &u.AvatarURL)
+		i, err := scanIssue(rows)
 		if err != nil {
 			return nil, err
 		}
 		res = append(res, i)
 	}
+	return res, nil
+}
 
-	if err := includeLabelsToIssues(ctx, res); err != nil {
-		return nil, err
+// scanIssue scans selectIssueColumns of the current row. extra receives columns after them.
+func scanIssue(rows *sql.Rows, extra ...interface{}) (*IssueOld, error) {
+	u := &UserOld{}
+	i := &IssueOld{
+		Labels:     []*LabelOld{},
+		Assignees:  []*UserOld{},
+		ChannelIDs: []int{},
+		Tags:       []string{},
+		User:       u,
 	}
-	if err := includeAssigneesToIssues(ctx, res); err != nil {
+	dest := []interface{}{&i.ID, &i.Number, &i.Title, &i.RepoOwner, &i.RepoName, &i.State, &i.Locked, &i.Comments, &i.CreatedAt, &i.UpdatedAt, &i.ClosedAt, &i.IsPullRequest, &i.Body, &i.AlreadyRead, &i.Merged, &i.Muted,
+		&u.ID, &u.Login, &u.AvatarURL}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
 		return nil, err
 	}
-
-	return res, nil
+	return i, nil
 }
 
-func buildFilterForSelectIssues(f *SearchIssueFilter) string {
+// buildFilterForSelectIssues returns SQL conditions for f and their arguments.
+// readCol is a SQL expression of the read state, which is returned by alreadyReadColumn.
+func buildFilterForSelectIssues(f *SearchIssueFilter, readCol string) (string, []interface{}) {
 	res := ""
+	args := []interface{}{}
 	if f.Issue && !f.PullRequest {
 		res += " AND i.isPullRequest = 0 "
 	}
@@ -243,14 +492,111 @@ func buildFilterForSelectIssues(f *SearchIssueFilter) string {
 	}
 
 	if f.Read && !f.Unread {
-		res += " AND i.alreadyRead = 1 "
+		res += fmt.Sprintf(" AND %s = 1 ", readCol)
 	}
 	if !f.Read && f.Unread {
-		res += " AND i.alreadyRead = 0 "
+		res += fmt.Sprintf(" AND %s = 0 ", readCol)
+	}
+
+	if f.Snoozed {
+		res += " AND i.id IN (select issueID from snoozes) "
+	} else {
+		res += " AND i.id NOT IN (select issueID from snoozes) "
+	}
+
+	if f.Muted {
+		res += " AND i.muted = 1 "
+	} else {
+		res += " AND i.muted = 0 "
+	}
+
+	if f.Starred {
+		res += " AND i.id IN (select issueID from issue_stars) "
+	}
+	if f.HasNote {
+		res += " AND i.id IN (select issueID from issue_notes) "
+	}
+
+	// Pull requests referring to the issue, or pull requests the issue refers to.
+	// They are separated so that each of them uses an index of issue_references.
+	if f.HasOpenLinkedPullRequest {
+		res += ` AND (exists (
+			select 1
+			from issue_references as r, issues as p
+			where
+				r.targetOwner = lower(i.repoOwner) AND r.targetName = lower(i.repoName) AND r.targetNumber = i.number AND
+				p.id = r.issueID AND p.isPullRequest = 1 AND p.closedAt is null
+		) OR exists (
+			select 1
+			from issue_references as r, issues as p
+			where
+				r.issueID = i.id AND
+				p.number = r.targetNumber AND lower(p.repoOwner) = r.targetOwner AND lower(p.repoName) = r.targetName AND
+				p.isPullRequest = 1 AND p.closedAt is null
+		)) `
+	}
+
+	listConds := []struct {
+		filter  StringListFilter
+		include string
+		exclude string
+	}{
+		{
+			filter: f.Labels,
+			include: `exists (
+				select 1 from assigned_labels_to_issue as li, labels as l
+				where li.issueID = i.id AND l.id = li.labelID AND l.name collate nocase IN (?)
+			)`,
+		},
+		{
+			filter:  f.Repositories,
+			include: `(i.repoOwner || '/' || i.repoName) collate nocase IN (?)`,
+		},
+		{
+			filter:  f.Authors,
+			include: `i.userID IN (select id from github_users where login collate nocase IN (?))`,
+		},
+		{
+			filter: f.Assignees,
+			include: `exists (
+				select 1 from assigned_users_to_issue as ui, github_users as au
+				where ui.issueID = i.id AND au.id = ui.userID AND au.login collate nocase IN (?)
+			)`,
+		},
+		{
+			filter:  f.Tags,
+			include: `i.id IN (select issueID from issue_tags where name IN (?))`,
+		},
+		{
+			filter:  f.Milestones,
+			include: `i.milestoneID IN (select id from milestones where title IN (?))`,
+			// Issues without milestone do not have the excluded milestones.
+			exclude: `(i.milestoneID is null OR i.milestoneID NOT IN (select id from milestones where title IN (?)))`,
+		},
+	}
+	if f.Text != "" {
+		res += ` AND i.id IN (select rowid from issues_fts where issues_fts match ?) `
+		args = append(args, buildFullTextQuery(f.Text))
 	}
 
-	if f.Closed && f.Open && f.Merged {
-		return res
+	for _, c := range listConds {
+		if len(c.filter.Include) != 0 {
+			res += " AND " + c.include + " "
+			args = append(args, c.filter.Include)
+		}
+		if len(c.filter.Exclude) != 0 {
+			if c.exclude == "" {
+				res += " AND NOT " + c.include + " "
+			} else {
+				res += " AND " + c.exclude + " "
+			}
+			args = append(args, c.filter.Exclude)
+		}
+	}
+
+	// No states means all states, like Issue and PullRequest.
+	if f.Closed == f.Open && f.Open == f.Merged {
+		return res, args
 	}
 
 	s := []string{}
@@ -267,7 +613,26 @@ func buildFilterForSelectIssues(f *SearchIssueFilter) string {
 
 	res += fmt.Sprintf(" AND (%s)", strings.Join(s, " OR "))
 
-	return res
+	return res, args
+}
+
+func includeRelationsToIssues(ctx context.Context, issues []*IssueOld) error {
+	if err := includeLabelsToIssues(ctx, issues); err != nil {
+		return err
+	}
+	if err := includeAssigneesToIssues(ctx, issues); err != nil {
+		return err
+	}
+	if err := includeChannelIDsToIssues(ctx, issues); err != nil {
+		return err
+	}
+	if err := includeSnoozesToIssues(ctx, issues); err != nil {
+		return err
+	}
+	if err := includeAnnotationsToIssues(ctx, issues); err != nil {
+		return err
+	}
+	return nil
 }
 
 func includeLabelsToIssues(ctx context.Context, issues []*IssueOld) error {
@@ -344,6 +709,39 @@ func includeAssigneesToIssues(ctx context.Context, issues []*IssueOld) error {
 	return nil
 }
 
+func includeChannelIDsToIssues(ctx context.Context, issues []*IssueOld) error {
+	issueIDs := make([]int, len(issues))
+	issueMap := make(map[int]*IssueOld, len(issues))
+	for idx, i := range issues {
+		issueIDs[idx] = i.ID
+		issueMap[i.ID] = i
+	}
+
+	rows, err := gormConn.Raw(`
+		select distinct
+			channelID, issueID
+		from
+			channel_issues
+		where
+			issueID IN (?)
+		;
+	`, issueIDs).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channelID, issueID int
+		if err := rows.Scan(&channelID, &issueID); err != nil {
+			return err
+		}
+		issueMap[issueID].ChannelIDs = append(issueMap[issueID].ChannelIDs, channelID)
+	}
+
+	return nil
+}
+
 var RepoFromIssueUrlRe = regexp.MustCompile(`/([^/]+)/([^/]+)/issues/\d+$`)
 
 func repoInfoFromIssue(i github.Issue) (string, string) {
@@ -365,11 +763,7 @@ func ImportIssues(ctx context.Context, issues []github.Issue, channelID int, que
 
 			user := i.GetUser()
 			userID := user.GetID()
-			err := tx.Exec(`
-				replace into github_users
-				(id, login, avatarURL)
-				values (?, ?, ?)
-			`, userID, user.GetLogin(), user.GetAvatarURL()).Error
+			err := upsertGitHubUser(ctx, user, tx)
 			if err != nil {
 				return errors.WithStack(err)
 			}
@@ -380,12 +774,14 @@ func ImportIssues(ctx context.Context, issues []github.Issue, channelID int, que
 			exist := !res.RecordNotFound()
 			var prevUpdatedAt time.Time
 			var prevAlreadyRead bool
+			var prevMuted bool
 			if !exist {
 				// do nothing
 			} else if res.Error != nil {
 				return errors.WithStack(res.Error)
 			} else {
 				prevAlreadyRead = issueTmp.AlreadyRead
+				prevMuted = issueTmp.Muted
 				prevUpdatedAt, err = parseTime(issueTmp.UpdatedAt)
 				if err != nil {
 					return errors.WithStack(err)
@@ -415,6 +811,19 @@ func ImportIssues(ctx context.Context, issues []github.Issue, channelID int, que
 				}
 			}
 
+			if exist && !prevUpdatedAt.Equal(i.GetUpdatedAt()) {
+				if err := wakeSnoozedIssueByUpdate(ctx, int(id), tx); err != nil {
+					return errors.WithStack(err)
+				}
+				// Muted issues are kept read in channels too.
+				if !prevMuted {
+					err := tx.Exec(`update channel_issues set alreadyRead = 0 where issueID = ?`, id).Error
+					if err != nil {
+						return errors.WithStack(err)
+					}
+				}
+			}
+
 			if exist {
 				err = tx.Exec(`
 					update issues
@@ -422,7 +831,7 @@ func ImportIssues(ctx context.Context, issues []github.Issue, channelID int, que
 					createdAt = ?, updatedAt = ?, closedAt = ?, isPullRequest = ?, body = ?, milestoneID = ?, alreadyRead = ?
 					where id = ?
 				`, i.GetNumber(), i.GetTitle(), userID, repoOwner, repoName, i.GetState(), i.GetLocked(), i.GetComments(),
-					createdAt, updatedAt, closedAt, i.IsPullRequest(), i.GetBody(), milestoneID, prevAlreadyRead && prevUpdatedAt.Equal(i.GetUpdatedAt()), id).Error
+					createdAt, updatedAt, closedAt, i.IsPullRequest(), i.GetBody(), milestoneID, prevMuted || (prevAlreadyRead && prevUpdatedAt.Equal(i.GetUpdatedAt())), id).Error
 				if err != nil {
 					return errors.WithStack(err)
 				}
@@ -447,12 +856,19 @@ func ImportIssues(ctx context.Context, issues []github.Issue, channelID int, que
 			if err := importAssignees(ctx, i, tx); err != nil {
 				return errors.WithStack(err)
 			}
+			if err := importIssueReferences(ctx, i, repoOwner, repoName, tx); err != nil {
+				return errors.WithStack(err)
+			}
 
+			// The channel read state is inherited from other queries of the channel.
+			// If the issue is new to the channel, it is read only when it is too old or muted.
+			tooOld := i.GetUpdatedAt().Before(time.Now().Add(-24 * 30 * time.Hour))
 			err = tx.Exec(`
-				replace into channel_issues
-				(issueID, channelID, queryID)
-				values (?, ?, ?)
-			`, id, channelID, q.ID).Error
+				insert into channel_issues
+				(issueID, channelID, queryID, alreadyRead)
+				values (?, ?, ?, coalesce((select min(alreadyRead) from channel_issues where issueID = ? AND channelID = ?), ?))
+				on conflict(channelID, issueID, queryID) do nothing
+			`, id, channelID, q.ID, id, channelID, tooOld || prevMuted).Error
 			if err != nil {
 				return errors.WithStack(err)
 			}
@@ -490,9 +906,11 @@ func importLabels(ctx context.Context, issue github.Issue, tx *gorm.DB) error {
 		labelID := label.GetID()
 
 		err := tx.Exec(`
-				replace into labels
+				insert into labels
 				(id, name, color, 'default')
 				VALUES (?, ?, ?, ?)
+				on conflict(id) do update set
+				name = excluded.name, color = excluded.color, 'default' = excluded.'default'
 			`, labelID, label.GetName(), label.GetColor(), label.GetDefault()).Error
 		if err != nil {
 			return err
@@ -511,6 +929,18 @@ func importLabels(ctx context.Context, issue github.Issue, tx *gorm.DB) error {
 	return nil
 }
 
+// upsertGitHubUser does not use "replace into",
+// because it deletes the existing row and the deletion cascades to issues.
+func upsertGitHubUser(ctx context.Context, user *github.User, tx *gorm.DB) error {
+	return tx.Exec(`
+		insert into github_users
+		(id, login, avatarURL)
+		VALUES (?, ?, ?)
+		on conflict(id) do update set
+		login = excluded.login, avatarURL = excluded.avatarURL
+	`, user.GetID(), user.GetLogin(), user.GetAvatarURL()).Error
+}
+
 func insertMilestone(ctx context.Context, milestone *github.Milestone, tx *gorm.DB) error {
 	mID := milestone.GetID()
 	createdAt := fmtTime(milestone.GetCreatedAt())
@@ -524,9 +954,12 @@ func insertMilestone(ctx context.Context, milestone *github.Milestone, tx *gorm.
 	}
 
 	err := tx.Exec(`
-			replace into milestones
+			insert into milestones
 			(id, number, title, description, state, createdAt, updatedAt, closedAt)
 			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			on conflict(id) do update set
+			number = excluded.number, title = excluded.title, description = excluded.description, state = excluded.state,
+			createdAt = excluded.createdAt, updatedAt = excluded.updatedAt, closedAt = excluded.closedAt
 		`, mID, milestone.GetNumber(), milestone.GetTitle(), milestone.GetDescription(), milestone.GetState(), createdAt, updatedAt, closedAt).Error
 	if err != nil {
 		return err
@@ -548,11 +981,7 @@ func importAssignees(ctx context.Context, issue github.Issue, tx *gorm.DB) error
 	for _, user := range issue.Assignees {
 		userID := user.GetID()
 
-		err := tx.Exec(`
-				replace into github_users
-				(id, login, avatarURL)
-				VALUES (?, ?, ?)
-			`, userID, user.GetLogin(), user.GetAvatarURL()).Error
+		err := upsertGitHubUser(ctx, user, tx)
 		if err != nil {
 			return err
 		}
@@ -570,14 +999,74 @@ func importAssignees(ctx context.Context, issue github.Issue, tx *gorm.DB) error
 	return nil
 }
 
+// fmtTime formats t in UTC, so stored times are ordered by string comparison.
 func fmtTime(t time.Time) string {
-	return t.Format(time.RFC3339)
+	return t.UTC().Format(time.RFC3339)
 }
 
 func parseTime(s string) (time.Time, error) {
 	return time.Parse(time.RFC3339, s)
 }
 
+func ExistChannelIssue(ctx context.Context, channelID int, issueID int) (bool, error) {
+	var cnt int
+	err := gormConn.Raw(`
+		select count(*)
+		from channel_issues
+		where channelID = ? AND issueID = ?
+	`, channelID, issueID).Row().Scan(&cnt)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return cnt > 0, nil
+}
+
+// SelectChangedFiles returns false as the second value if files for the updatedAt are not stored.
+func SelectChangedFiles(ctx context.Context, issueID int, updatedAt string) ([]string, bool, error) {
+	var storedUpdatedAt string
+	err := gormConn.Raw(`select updatedAt from changed_files_status where issueID = ?`, issueID).Row().Scan(&storedUpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if storedUpdatedAt != updatedAt {
+		return nil, false, nil
+	}
+
+	res := make([]string, 0)
+	err = gormConn.Table("changed_files").Where("issueID = ?", issueID).Pluck("filename", &res).Error
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	return res, true, nil
+}
+
+func ImportChangedFiles(ctx context.Context, issueID int, updatedAt string, filenames []string) error {
+	return txGorm(func(tx *gorm.DB) error {
+		err := tx.Exec(`delete from changed_files where issueID = ?`, issueID).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, f := range filenames {
+			err := tx.Exec(`
+				insert into changed_files
+				(issueID, filename)
+				values (?, ?)
+			`, issueID, f).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return tx.Exec(`
+			replace into changed_files_status
+			(issueID, updatedAt)
+			values (?, ?)
+		`, issueID, updatedAt).Error
+	})
+}
+
 func UpdateIssueAlreadyRead(ctx context.Context, issueID int, alreadyRead bool) error {
 	return gormConn.Exec(`
 		update issues
@@ -586,6 +1075,59 @@ func UpdateIssueAlreadyRead(ctx context.Context, issueID int, alreadyRead bool)
 	`, alreadyRead, issueID).Error
 }
 
+// UpdateIssuesAlreadyRead updates all the issues in the transaction.
+func UpdateIssuesAlreadyRead(ctx context.Context, issueIDs []int, alreadyRead bool, tx *gorm.DB) error {
+	for _, chunk := range chunkInts(issueIDs) {
+		err := tx.Exec(`
+			update issues
+			set alreadyRead = ?
+			where id IN (?)
+		`, alreadyRead, chunk).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// SelectIssueIDs returns IDs of all issues matching q regardless of the pagination.
+func SelectIssueIDs(ctx context.Context, q *SearchIssuesQuery) ([]int, error) {
+	channelIssues, args, err := ChannelIssuesQuery(ctx, q.channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	readCol, err := alreadyReadColumn(ctx, q.channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	additionalConds, filterArgs := buildFilterForSelectIssues(q.filter, readCol)
+	args = append(args, filterArgs...)
+
+	res := make([]int, 0)
+	rows, err := gormConn.Raw(fmt.Sprintf(`
+		select
+			i.id
+		from
+			issues as i
+		where
+			i.id IN (%s)
+			%s
+	`, channelIssues, additionalConds), args...).Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
+
 type AccountForGitHubAPI struct {
 	accessToken string
 	id          int
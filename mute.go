@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v21/github"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// MuteIssue mutes or unmutes the issue.
+// Muted issues are marked as read, and ImportIssues keeps them read even if they are updated.
+func MuteIssue(ctx context.Context, issueID int, muted bool, tx *gorm.DB) error {
+	err := tx.Exec(`update issues set muted = ? where id = ?`, muted, issueID).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !muted {
+		return nil
+	}
+	return errors.WithStack(tx.Exec(`update issues set alreadyRead = 1 where id = ?`, issueID).Error)
+}
+
+// UnsubscribeIssueThread ignores the GitHub notification thread of the issue
+// for each account which has the issue in its channels.
+// GitHub App accounts are skipped, because installations do not have notifications.
+// It returns the number of unsubscribed threads.
+func UnsubscribeIssueThread(ctx context.Context, issueID int) (int, error) {
+	issue := Issue{}
+	if err := gormConn.First(&issue, issueID).Error; err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	accounts := make([]Account, 0)
+	err := gormConn.
+		Where(`appID is null AND id IN (
+			select c.accountID from channels as c, channel_issues as ci
+			where c.id = ci.channelID AND ci.issueID = ?
+		)`, issueID).
+		Find(&accounts).Error
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	cnt := 0
+	for _, a := range accounts {
+		client := ghClient(ctx, a)
+		ok, err := unsubscribeIssueThread(ctx, client, issue)
+		if err != nil {
+			return cnt, err
+		}
+		if ok {
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+// unsubscribeIssueThread returns false if the account does not have a notification thread for the issue.
+func unsubscribeIssueThread(ctx context.Context, client *github.Client, issue Issue) (bool, error) {
+	issueSuffix := fmt.Sprintf("/repos/%s/%s/issues/%d", issue.RepoOwner, issue.RepoName, issue.Number)
+	pullSuffix := fmt.Sprintf("/repos/%s/%s/pulls/%d", issue.RepoOwner, issue.RepoName, issue.Number)
+
+	opt := &github.NotificationListOptions{
+		All:         true,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		ns, resp, err := client.Activity.ListRepositoryNotifications(ctx, issue.RepoOwner, issue.RepoName, opt)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		for _, n := range ns {
+			u := n.GetSubject().GetURL()
+			if !strings.HasSuffix(u, issueSuffix) && !strings.HasSuffix(u, pullSuffix) {
+				continue
+			}
+
+			_, _, err := client.Activity.SetThreadSubscription(ctx, n.GetID(), &github.Subscription{Ignored: github.Bool(true)})
+			if err != nil {
+				return false, errors.WithStack(err)
+			}
+			return true, nil
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type UnreadCountNotifier struct {
-	chs []chan *UnreadCount
+	chs []chan *WsMessage
 	mu  sync.Mutex
 }
 
@@ -18,8 +21,13 @@ type UnreadCount struct {
 	Count     int
 }
 
-func (n *UnreadCountNotifier) Subscribe() <-chan *UnreadCount {
-	ch := make(chan *UnreadCount)
+type ViewUnreadCount struct {
+	ViewID int
+	Count  int
+}
+
+func (n *UnreadCountNotifier) Subscribe() <-chan *WsMessage {
+	ch := make(chan *WsMessage)
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.chs = append(n.chs, ch)
@@ -27,14 +35,14 @@ func (n *UnreadCountNotifier) Subscribe() <-chan *UnreadCount {
 	return ch
 }
 
-func (n *UnreadCountNotifier) Unsubscribe(ch <-chan *UnreadCount) {
+func (n *UnreadCountNotifier) Unsubscribe(ch <-chan *WsMessage) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	for idx, c := range n.chs {
 		if ch == c {
 			chs := append(n.chs[:idx], n.chs[idx+1:]...)
-			newChs := make([]chan *UnreadCount, len(chs))
+			newChs := make([]chan *WsMessage, len(chs))
 			copy(newChs, chs)
 			n.chs = newChs
 			return
@@ -43,6 +51,18 @@ func (n *UnreadCountNotifier) Unsubscribe(ch <-chan *UnreadCount) {
 }
 
 func (n *UnreadCountNotifier) Notify(msg *UnreadCount) {
+	n.notify(&WsMessage{Type: WsTypeUnreadCount, Payload: msg})
+}
+
+func (n *UnreadCountNotifier) NotifyView(msg *ViewUnreadCount) {
+	n.notify(&WsMessage{Type: WsTypeViewUnreadCount, Payload: msg})
+}
+
+func (n *UnreadCountNotifier) NotifyInbox(msg *InboxUnreadCount) {
+	n.notify(&WsMessage{Type: WsTypeInboxUnreadCount, Payload: msg})
+}
+
+func (n *UnreadCountNotifier) notify(msg *WsMessage) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
@@ -50,3 +70,31 @@ func (n *UnreadCountNotifier) Notify(msg *UnreadCount) {
 		c <- msg
 	}
 }
+
+// NotifyUnreadCounts notifies unread counts of the channels,
+// unread counts of views which include the channels, and the unread count of the inbox.
+func NotifyUnreadCounts(ctx context.Context, cnts []*UnreadCount) error {
+	channelIDs := make([]int, len(cnts))
+	for idx, cnt := range cnts {
+		unreadCountNotifier.Notify(cnt)
+		channelIDs[idx] = cnt.ChannelID
+	}
+
+	viewCnts, err := ViewsUnreadCountForChannels(ctx, channelIDs)
+	if err != nil {
+		return err
+	}
+	for _, cnt := range viewCnts {
+		unreadCountNotifier.NotifyView(cnt)
+	}
+
+	if len(cnts) == 0 {
+		return nil
+	}
+	inboxCnt, err := SelectInboxUnreadCount(ctx)
+	if err != nil {
+		return err
+	}
+	unreadCountNotifier.NotifyInbox(inboxCnt)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPerPage = 100
+	maxPerPage     = 100
+)
+
+// Columns which issues can be sorted by.
+var issueSortColumns = map[string]string{
+	"updated":  "i.updatedAt",
+	"created":  "i.createdAt",
+	"comments": "i.comments",
+}
+
+// IssuesCursor points the last issue of a page.
+// The next page starts from the issue just after (Value, ID) in the sort order,
+// so pages are not shifted even if new issues are inserted while paginating.
+type IssuesCursor struct {
+	// The value of the sort column
+	Value interface{}
+	ID    int
+	// The cursor is valid only for the same sort and order.
+	Sort  string
+	Order string
+}
+
+func newIssuesCursor(i *IssueOld, sort, order string) *IssuesCursor {
+	c := &IssuesCursor{ID: i.ID, Sort: sort, Order: order}
+	switch sort {
+	case "created":
+		c.Value = i.CreatedAt
+	case "comments":
+		c.Value = i.Comments
+	default:
+		c.Value = i.UpdatedAt
+	}
+	return c
+}
+
+func (c *IssuesCursor) Encode() (string, error) {
+	b, err := json.Marshal([]interface{}{c.Value, c.ID, c.Sort, c.Order})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func DecodeIssuesCursor(s string) (*IssuesCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "cursor is invalid")
+	}
+	var v []interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrap(err, "cursor is invalid")
+	}
+	if len(v) != 4 {
+		return nil, errors.New("cursor is invalid")
+	}
+	id, ok := v[1].(float64)
+	sort, ok2 := v[2].(string)
+	order, ok3 := v[3].(string)
+	if !ok || !ok2 || !ok3 {
+		return nil, errors.New("cursor is invalid")
+	}
+	value, err := cursorValue(sort, v[0])
+	if err != nil {
+		return nil, err
+	}
+	return &IssuesCursor{Value: value, ID: int(id), Sort: sort, Order: order}, nil
+}
+
+// cursorValue checks the decoded value against the type of the sort column.
+func cursorValue(sort string, v interface{}) (interface{}, error) {
+	switch sort {
+	case "comments":
+		n, ok := v.(float64)
+		if !ok || n != float64(int(n)) {
+			return nil, errors.Errorf("cursor is invalid: %v is not a number of comments", v)
+		}
+		return int(n), nil
+	case "updated", "created":
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("cursor is invalid: %v is not a time", v)
+		}
+		if _, err := parseTime(s); err != nil {
+			return nil, errors.Wrap(err, "cursor is invalid")
+		}
+		return s, nil
+	default:
+		return nil, errors.Errorf("cursor is invalid: unknown sort %s", sort)
+	}
+}
+
+// match returns an error if the cursor was created for another sort or order.
+func (c *IssuesCursor) match(sort, order string) error {
+	if c.Sort != sort || c.Order != order {
+		return errors.Errorf("cursor is for sort %s and order %s, but got sort %s and order %s", c.Sort, c.Order, sort, order)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Read modes of channels.
+// In the global mode, the read state of an issue is shared with all channels (issues.alreadyRead).
+// In the channel mode, the read state is stored for each channel (channel_issues.alreadyRead),
+// so reading an issue in the channel does not affect other channels and vice versa.
+// Virtual channels always use the global mode.
+// Reading an issue without a channel, for example in the inbox, or in a virtual channel
+// updates both the global read state and the read states in channels with the channel mode.
+const (
+	ReadModeGlobal  = "global"
+	ReadModeChannel = "channel"
+)
+
+// unreadCondition is a SQL condition for unread issues in channels.
+// It requires "channels as c", "channel_issues as ci" and "issues as i".
+const unreadCondition = `
+	(CASE c.readMode WHEN 'channel' THEN (ci.alreadyRead OR i.muted) ELSE i.alreadyRead END) = 0
+`
+
+func (c Channel) HasOwnReadState() bool {
+	return c.ReadMode == ReadModeChannel && !c.IsVirtual()
+}
+
+// alreadyReadColumn returns a SQL expression of the read state of issue "i" in the channels.
+// The channel read state is used only when channelIDs is a channel with the channel mode.
+func alreadyReadColumn(ctx context.Context, channelIDs []int) (string, error) {
+	c, err := ownReadStateChannel(ctx, channelIDs)
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		return "i.alreadyRead", nil
+	}
+	return fmt.Sprintf(`
+		(i.muted OR (select min(ci.alreadyRead) from channel_issues as ci where ci.issueID = i.id AND ci.channelID = %d))
+	`, c.ID), nil
+}
+
+// ownReadStateChannel returns nil if channelIDs is not a channel with the channel mode.
+func ownReadStateChannel(ctx context.Context, channelIDs []int) (*Channel, error) {
+	if len(channelIDs) != 1 {
+		return nil, nil
+	}
+	c := &Channel{}
+	res := gormConn.First(c, channelIDs[0])
+	if res.RecordNotFound() {
+		return nil, nil
+	}
+	if res.Error != nil {
+		return nil, errors.WithStack(res.Error)
+	}
+	if !c.HasOwnReadState() {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// UpdateIssuesAlreadyReadInChannel updates the read state in the channel if it has the channel mode,
+// otherwise it updates the global read state.
+// channelID can be 0 if the issues are read without a channel. See the read modes for the states updated.
+func UpdateIssuesAlreadyReadInChannel(ctx context.Context, issueIDs []int, channelID int, alreadyRead bool, tx *gorm.DB) error {
+	c, err := ownReadStateChannel(ctx, []int{channelID})
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		if err := UpdateIssuesAlreadyRead(ctx, issueIDs, alreadyRead, tx); err != nil {
+			return err
+		}
+		all, err := readInAllChannels(channelID, tx)
+		if err != nil || !all {
+			return err
+		}
+		for _, chunk := range chunkInts(issueIDs) {
+			err := tx.Exec(`
+				update channel_issues
+				set alreadyRead = ?
+				where issueID IN (?) AND channelID IN (select id from channels where readMode = ?)
+			`, alreadyRead, chunk, ReadModeChannel).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	}
+
+	for _, chunk := range chunkInts(issueIDs) {
+		err := tx.Exec(`
+			update channel_issues
+			set alreadyRead = ?
+			where channelID = ? AND issueID IN (?)
+		`, alreadyRead, c.ID, chunk).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// readInAllChannels returns true if reading issues in the channel updates read states in all channels.
+// It is true for no channel and virtual channels, which do not belong to the channels of the issues.
+func readInAllChannels(channelID int, tx *gorm.DB) (bool, error) {
+	if channelID == 0 {
+		return true, nil
+	}
+	c := &Channel{}
+	res := tx.First(c, channelID)
+	if res.RecordNotFound() {
+		return true, nil
+	}
+	if res.Error != nil {
+		return false, errors.WithStack(res.Error)
+	}
+	return c.IsVirtual(), nil
+}
+
+// UpdateChannelReadMode changes the read mode of the channel.
+// When the channel mode is enabled, the channel read state starts from the global read state.
+func UpdateChannelReadMode(ctx context.Context, channelID int, mode string) error {
+	if mode != ReadModeGlobal && mode != ReadModeChannel {
+		return errors.Errorf("Unknown read mode: %s", mode)
+	}
+
+	return txGorm(func(tx *gorm.DB) error {
+		c := &Channel{}
+		if err := tx.First(c, channelID).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		if c.IsVirtual() && mode == ReadModeChannel {
+			return errors.New("Virtual channels cannot have the channel read mode")
+		}
+		if c.ReadMode == mode {
+			return nil
+		}
+
+		err := tx.Exec(`update channels set readMode = ? where id = ?`, mode, channelID).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if mode != ReadModeChannel {
+			return nil
+		}
+		err = tx.Exec(`
+			update channel_issues
+			set alreadyRead = (select i.alreadyRead from issues as i where i.id = channel_issues.issueID)
+			where channelID = ?
+		`, channelID).Error
+		return errors.WithStack(err)
+	})
+}
+
+// includeChannelReadStateToIssues overwrites AlreadyRead of issues by the read state in the channels,
+// if channelIDs is a channel with the channel mode.
+func includeChannelReadStateToIssues(ctx context.Context, issues []*IssueOld, channelIDs []int) error {
+	c, err := ownReadStateChannel(ctx, channelIDs)
+	if err != nil {
+		return err
+	}
+	if c == nil || len(issues) == 0 {
+		return nil
+	}
+
+	issueMap := make(map[int]*IssueOld, len(issues))
+	ids := make([]int, 0, len(issues))
+	for _, i := range issues {
+		issueMap[i.ID] = i
+		ids = append(ids, i.ID)
+	}
+
+	for _, chunk := range chunkInts(ids) {
+		rows, err := gormConn.Raw(`
+			select issueID, min(alreadyRead)
+			from channel_issues
+			where channelID = ? AND issueID IN (?)
+			group by issueID
+		`, c.ID, chunk).Rows()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for rows.Next() {
+			var issueID int
+			var read bool
+			if err := rows.Scan(&issueID, &read); err != nil {
+				rows.Close()
+				return errors.WithStack(err)
+			}
+			i := issueMap[issueID]
+			i.AlreadyRead = read || i.Muted
+		}
+		rows.Close()
+	}
+	return nil
+}
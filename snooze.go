@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Snoozed issues are hidden from channels until they wake up.
+type Snooze struct {
+	// null if the issue wakes up only by update
+	Until NullStringJSON
+	// The issue wakes up when it is updated
+	UntilUpdated bool
+}
+
+const wakeSnoozedIssuesInterval = 10 * time.Second
+
+// StartWakeSnoozedIssues wakes snoozed issues periodically until ctx is cancelled.
+func StartWakeSnoozedIssues(ctx context.Context) error {
+	go func() {
+		for ctx.Err() == nil {
+			err := errors.WithStack(startWakeSnoozedIssues(ctx))
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("%+v\n", err)
+			err = sendErrToSlack(err)
+			if err != nil {
+				log.Printf("%+v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+	return nil
+}
+
+func startWakeSnoozedIssues(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wakeSnoozedIssuesInterval):
+		}
+
+		ids, err := WakeSnoozedIssues(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		cnts, err := UnreadCountForIssue(ctx, ids)
+		if err != nil {
+			return err
+		}
+		if err := NotifyUnreadCounts(ctx, cnts); err != nil {
+			return err
+		}
+	}
+}
+
+// SnoozeIssue hides the issue until the time, or until the issue is updated.
+// until can be nil if untilUpdated is true.
+func SnoozeIssue(ctx context.Context, issueID int, until *time.Time, untilUpdated bool, tx *gorm.DB) error {
+	var u sql.NullString
+	if until != nil {
+		u.Valid = true
+		u.String = fmtTime(until.UTC())
+	}
+
+	var updatedAt sql.NullString
+	if untilUpdated {
+		updatedAt.Valid = true
+		err := tx.Raw(`select updatedAt from issues where id = ?`, issueID).Row().Scan(&updatedAt.String)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.WithStack(tx.Exec(`
+		replace into snoozes
+		(issueID, until, updatedAt)
+		values (?, ?, ?)
+	`, issueID, u, updatedAt).Error)
+}
+
+func UnsnoozeIssue(ctx context.Context, issueID int, tx *gorm.DB) error {
+	return errors.WithStack(tx.Exec(`delete from snoozes where issueID = ?`, issueID).Error)
+}
+
+// WakeSnoozedIssues wakes issues snoozed until now, and marks them as unread unless they are muted.
+// It returns IDs of the woken issues.
+func WakeSnoozedIssues(ctx context.Context, now time.Time) ([]int, error) {
+	var ids []int
+	err := txGorm(func(tx *gorm.DB) error {
+		err := tx.Table("snoozes").
+			Where("until is not null AND until <= ?", fmtTime(now.UTC())).
+			Pluck("issueID", &ids).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, chunk := range chunkInts(ids) {
+			err := tx.Exec(`delete from snoozes where issueID IN (?)`, chunk).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			err = tx.Exec(`update issues set alreadyRead = 0 where id IN (?) AND muted = 0`, chunk).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			err = tx.Exec(`update channel_issues set alreadyRead = 0 where issueID IN (?) AND issueID IN (select id from issues where muted = 0)`, chunk).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// wakeSnoozedIssueByUpdate is called when the issue is updated.
+// The issue is marked as unread by ImportIssues.
+func wakeSnoozedIssueByUpdate(ctx context.Context, issueID int, tx *gorm.DB) error {
+	return tx.Exec(`
+		delete from snoozes
+		where issueID = ? AND updatedAt is not null
+	`, issueID).Error
+}
+
+func includeSnoozesToIssues(ctx context.Context, issues []*IssueOld) error {
+	issueIDs := make([]int, len(issues))
+	issueMap := make(map[int]*IssueOld, len(issues))
+	for idx, i := range issues {
+		issueIDs[idx] = i.ID
+		issueMap[i.ID] = i
+	}
+
+	rows, err := gormConn.Raw(`
+		select
+			issueID, until, updatedAt is not null
+		from
+			snoozes
+		where
+			issueID IN (?)
+		;
+	`, issueIDs).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &Snooze{}
+		var issueID int
+		if err := rows.Scan(&issueID, &s.Until, &s.UntilUpdated); err != nil {
+			return err
+		}
+		issueMap[issueID].Snooze = s
+	}
+
+	return nil
+}
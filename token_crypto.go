@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Access tokens stored in the database are encrypted with AES-256-GCM.
+// The key is read from a key file, or derived from a passphrase with scrypt.
+const encryptedTokenPrefix = "enc:v1:"
+
+const (
+	settingTokenKeySalt  = "tokenKeySalt"
+	settingTokenKeyCheck = "tokenKeyCheck"
+	tokenKeyCheckText    = "korat"
+)
+
+// TokenKeyConfig specifies where the encryption key comes from.
+// Passphrase takes precedence over KeyFile.
+type TokenKeyConfig struct {
+	KeyFile    string
+	Passphrase string
+}
+
+var tokenCipherCache struct {
+	mu     sync.Mutex
+	cipher *TokenCipher
+}
+
+type TokenCipher struct {
+	aead cipher.AEAD
+}
+
+func newTokenCipher(key []byte) (*TokenCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &TokenCipher{aead: aead}, nil
+}
+
+func (c *TokenCipher) encrypt(plain string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.WithStack(err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *TokenCipher) decrypt(s string) (string, error) {
+	if !isEncryptedToken(s) {
+		return s, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, encryptedTokenPrefix))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return "", errors.New("Encrypted token is too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", errors.New("Cannot decrypt the access token. The key may be wrong")
+	}
+	return string(plain), nil
+}
+
+func isEncryptedToken(s string) bool {
+	return strings.HasPrefix(s, encryptedTokenPrefix)
+}
+
+// initTokenCipher loads the cipher for gormConn from conf, and encrypts plain tokens in the database.
+// It is called after migrations.
+func initTokenCipher(conf *TokenKeyConfig) error {
+	var c *TokenCipher
+	err := txGorm(func(tx *gorm.DB) error {
+		var err error
+		c, err = loadTokenCipher(tx, conf)
+		if err != nil {
+			return err
+		}
+		return encryptStoredTokens(tx, c)
+	})
+	if err != nil {
+		return err
+	}
+	tokenCipherCache.mu.Lock()
+	defer tokenCipherCache.mu.Unlock()
+	tokenCipherCache.cipher = c
+	return nil
+}
+
+// currentTokenCipher returns the cipher loaded by initTokenCipher.
+func currentTokenCipher() (*TokenCipher, error) {
+	tokenCipherCache.mu.Lock()
+	defer tokenCipherCache.mu.Unlock()
+	if tokenCipherCache.cipher == nil {
+		return nil, errors.New("Encryption key of access tokens is not loaded")
+	}
+	return tokenCipherCache.cipher, nil
+}
+
+// loadTokenCipher builds the cipher from conf, and checks the key matches the database.
+// db is a transaction in migrations.
+func loadTokenCipher(db *gorm.DB, conf *TokenKeyConfig) (*TokenCipher, error) {
+	if conf == nil {
+		return nil, errors.New("Encryption key of access tokens is not configured")
+	}
+	key, err := tokenKey(db, conf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newTokenCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	check, ok, err := getSetting(db, settingTokenKeyCheck)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return c, saveTokenKeyCheck(db, c)
+	}
+	if _, err := c.decrypt(check); err != nil {
+		return nil, errors.New("The encryption key does not match the database. Use the key used before, or run rekey with it")
+	}
+	return c, nil
+}
+
+func saveTokenKeyCheck(db *gorm.DB, c *TokenCipher) error {
+	check, err := c.encrypt(tokenKeyCheckText)
+	if err != nil {
+		return err
+	}
+	return setSetting(db, settingTokenKeyCheck, check)
+}
+
+func tokenKey(db *gorm.DB, conf *TokenKeyConfig) ([]byte, error) {
+	if conf.Passphrase != "" {
+		salt, ok, err := getSetting(db, settingTokenKeySalt)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			salt = hex.EncodeToString(b)
+			if err := setSetting(db, settingTokenKeySalt, salt); err != nil {
+				return nil, err
+			}
+		}
+		key, err := scrypt.Key([]byte(conf.Passphrase), []byte(salt), 1<<15, 8, 1, 32)
+		return key, errors.WithStack(err)
+	}
+
+	return readOrCreateKeyFile(conf.KeyFile)
+}
+
+// readOrCreateKeyFile reads a hex encoded 32 bytes key. It generates the key if the file does not exist.
+func readOrCreateKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("Key file is not specified")
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		log.Printf("Generated the encryption key of access tokens at %s. Back it up with the database, or stored tokens cannot be decrypted.\n", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.Errorf("Key file %s must contain a hex encoded 32 bytes key", path)
+	}
+	return key, nil
+}
+
+func encryptToken(token string) (string, error) {
+	if token == "" || isEncryptedToken(token) {
+		return token, nil
+	}
+	c, err := currentTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	return c.encrypt(token)
+}
+
+func decryptToken(token string) (string, error) {
+	if !isEncryptedToken(token) {
+		return token, nil
+	}
+	c, err := currentTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	return c.decrypt(token)
+}
+
+// encryptStoredTokens encrypts plain access tokens in the database.
+func encryptStoredTokens(tx *gorm.DB, c *TokenCipher) error {
+	accounts := make([]Account, 0)
+	if err := tx.Where("accessToken != '' AND accessToken NOT LIKE ?", encryptedTokenPrefix+"%").Find(&accounts).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	for _, a := range accounts {
+		enc, err := c.encrypt(a.AccessToken)
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(`update accounts set accessToken = ? where id = ?`, enc, a.ID).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// RekeyTokens encrypts stored access tokens with the new key.
+// The cache is locked until the new cipher is set, so tokens are not resolved with the old key meanwhile.
+// It refuses to run while the server uses the database, because the server keeps the old key.
+func RekeyTokens(newConf *TokenKeyConfig) error {
+	tokenCipherCache.mu.Lock()
+	defer tokenCipherCache.mu.Unlock()
+	oldCipher := tokenCipherCache.cipher
+	if oldCipher == nil {
+		return errors.New("Encryption key of access tokens is not loaded")
+	}
+
+	var newCipher *TokenCipher
+	err := txGorm(func(tx *gorm.DB) error {
+		if err := checkServerStopped(tx); err != nil {
+			return err
+		}
+		// The salt is regenerated for the new passphrase.
+		if err := tx.Exec(`delete from settings where name IN (?)`, []string{settingTokenKeySalt, settingTokenKeyCheck}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		var err error
+		newCipher, err = loadTokenCipher(tx, newConf)
+		if err != nil {
+			return err
+		}
+
+		accounts := make([]Account, 0)
+		if err := tx.Where("accessToken != ''").Find(&accounts).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		for _, a := range accounts {
+			plain, err := oldCipher.decrypt(a.AccessToken)
+			if err != nil {
+				return errors.Wrapf(err, "Account %d", a.ID)
+			}
+			enc, err := newCipher.encrypt(plain)
+			if err != nil {
+				return err
+			}
+			err = tx.Exec(`update accounts set accessToken = ? where id = ?`, enc, a.ID).Error
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tokenCipherCache.cipher = newCipher
+	return nil
+}
+
+func getSetting(db *gorm.DB, name string) (string, bool, error) {
+	var value string
+	err := db.Raw(`select value from settings where name = ?`, name).Row().Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+	return value, true, nil
+}
+
+func setSetting(db *gorm.DB, name, value string) error {
+	err := db.Exec(`
+		insert into settings (name, value) values (?, ?)
+		on conflict(name) do update set value = excluded.value
+	`, name, value).Error
+	return errors.WithStack(err)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTokenCipher(t *testing.T, b byte) *TokenCipher {
+	t.Helper()
+	c, err := newTokenCipher(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTokenCipher(t *testing.T) {
+	c := newTestTokenCipher(t, 1)
+
+	enc, err := c.encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedToken(enc) || strings.Contains(enc, "secret") {
+		t.Errorf("%q is not encrypted", enc)
+	}
+	enc2, err := c.encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc == enc2 {
+		t.Error("the nonce is reused")
+	}
+
+	plain, err := c.decrypt(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "secret" {
+		t.Errorf("got %q, want secret", plain)
+	}
+	// Plain tokens stored before the encryption are returned as they are.
+	if plain, err := c.decrypt("plain"); err != nil || plain != "plain" {
+		t.Errorf("got %q and %v, want plain", plain, err)
+	}
+
+	if _, err := newTestTokenCipher(t, 2).decrypt(enc); err == nil {
+		t.Error("decrypted with a wrong key")
+	}
+	if _, err := c.decrypt(encryptedTokenPrefix + "AAAA"); err == nil {
+		t.Error("decrypted a too short token")
+	}
+}
+
+func insertTestAccount(t *testing.T, name, token string) int {
+	t.Helper()
+	a := &Account{DisplayName: name, UrlBase: defaultUrlBase, ApiUrlBase: defaultApiUrlBase, AccessToken: token}
+	if err := gormConn.Create(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	return a.ID
+}
+
+func selectTestAccessToken(t *testing.T, id int) string {
+	t.Helper()
+	var token string
+	if err := gormConn.Raw(`select accessToken from accounts where id = ?`, id).Row().Scan(&token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInitTokenCipher(t *testing.T) {
+	openTestDB(t)
+	keyFile := filepath.Join(t.TempDir(), "token.key")
+
+	plainID := insertTestAccount(t, "plain", "plain token")
+	encrypted, err := newTestTokenCipher(t, 1).encrypt("other key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedID := insertTestAccount(t, "encrypted", encrypted)
+
+	if err := initTokenCipher(&TokenKeyConfig{KeyFile: keyFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain tokens are encrypted, and encrypted ones are skipped.
+	token := selectTestAccessToken(t, plainID)
+	if !isEncryptedToken(token) {
+		t.Errorf("%q is not encrypted", token)
+	}
+	if plain, err := decryptToken(token); err != nil || plain != "plain token" {
+		t.Errorf("got %q and %v, want the plain token", plain, err)
+	}
+	if token := selectTestAccessToken(t, encryptedID); token != encrypted {
+		t.Errorf("the encrypted token is changed to %q", token)
+	}
+	if token, err := encryptToken(encrypted); err != nil || token != encrypted {
+		t.Errorf("encryptToken changed the encrypted token to %q, %v", token, err)
+	}
+
+	// The generated key file is used again.
+	if err := initTokenCipher(&TokenKeyConfig{KeyFile: keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decryptToken(selectTestAccessToken(t, plainID)); err != nil || plain != "plain token" {
+		t.Errorf("got %q and %v, want the plain token", plain, err)
+	}
+
+	// Another key does not match the database.
+	if err := initTokenCipher(&TokenKeyConfig{KeyFile: filepath.Join(t.TempDir(), "wrong.key")}); err == nil {
+		t.Error("a wrong key file is accepted")
+	}
+	if err := initTokenCipher(&TokenKeyConfig{Passphrase: "wrong"}); err == nil {
+		t.Error("a wrong passphrase is accepted")
+	}
+}
+
+func TestRekeyTokens(t *testing.T) {
+	openTestDB(t)
+	id := insertTestAccount(t, "a", "token")
+	if err := initTokenCipher(&TokenKeyConfig{Passphrase: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	old := selectTestAccessToken(t, id)
+
+	if err := RekeyTokens(&TokenKeyConfig{Passphrase: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	token := selectTestAccessToken(t, id)
+	if token == old || !isEncryptedToken(token) {
+		t.Errorf("the token is not encrypted again: %q", token)
+	}
+	if plain, err := decryptToken(token); err != nil || plain != "token" {
+		t.Errorf("got %q and %v with the new cipher, want the token", plain, err)
+	}
+
+	if err := initTokenCipher(&TokenKeyConfig{Passphrase: "old"}); err == nil {
+		t.Error("the old passphrase is accepted after rekey")
+	}
+	if err := initTokenCipher(&TokenKeyConfig{Passphrase: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decryptToken(token); err != nil || plain != "token" {
+		t.Errorf("got %q and %v after reload, want the token", plain, err)
+	}
+}
+
+func TestRekeyTokensWhileServerRuns(t *testing.T) {
+	openTestDB(t)
+	id := insertTestAccount(t, "a", "token")
+	if err := initTokenCipher(&TokenKeyConfig{Passphrase: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	token := selectTestAccessToken(t, id)
+
+	if err := setSetting(gormConn, settingServerHeartbeat, fmtTime(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := RekeyTokens(&TokenKeyConfig{Passphrase: "new"}); err == nil {
+		t.Fatal("rekey ran while the server runs")
+	}
+	if selectTestAccessToken(t, id) != token {
+		t.Error("the token is changed by the refused rekey")
+	}
+	if err := initTokenCipher(&TokenKeyConfig{Passphrase: "old"}); err != nil {
+		t.Errorf("the old passphrase is rejected after the refused rekey: %v", err)
+	}
+
+	// The server stopped a while ago.
+	if err := setSetting(gormConn, settingServerHeartbeat, fmtTime(time.Now().Add(-serverHeartbeatTimeout))); err != nil {
+		t.Fatal(err)
+	}
+	if err := RekeyTokens(&TokenKeyConfig{Passphrase: "new"}); err != nil {
+		t.Fatal(err)
+	}
+}
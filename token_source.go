@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v21/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// TokenRef refers to an access token.
+// Literal tokens cannot be written in the config file.
+type TokenRef struct {
+	Literal string `yaml:"-" toml:"-"`
+	// Name of an environment variable
+	Env string `yaml:"env" toml:"env"`
+	// A command and its arguments, which prints the token to stdout. e.g. ["pass", "show", "github"]
+	Command []string `yaml:"command" toml:"command"`
+}
+
+// String describes the source without the token.
+func (t TokenRef) String() string {
+	switch {
+	case t.Env != "":
+		return "env:" + t.Env
+	case len(t.Command) != 0:
+		return "command:" + strings.Join(t.Command, " ")
+	default:
+		return "literal"
+	}
+}
+
+func (t TokenRef) isLiteral() bool {
+	return t.Env == "" && len(t.Command) == 0
+}
+
+func (t TokenRef) validate() error {
+	n := 0
+	if t.Literal != "" {
+		n++
+	}
+	if t.Env != "" {
+		n++
+	}
+	if len(t.Command) != 0 {
+		n++
+	}
+	if n != 1 {
+		return errors.New("token requires one of a literal, env or command")
+	}
+	return nil
+}
+
+func (t TokenRef) resolve(ctx context.Context) (string, error) {
+	if err := t.validate(); err != nil {
+		return "", err
+	}
+	if t.Literal != "" {
+		return decryptToken(t.Literal)
+	}
+	if t.Env != "" {
+		v := os.Getenv(t.Env)
+		if v == "" {
+			return "", errors.Errorf("Environment variable %s is empty", t.Env)
+		}
+		return v, nil
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, t.Command[0], t.Command[1:]...)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "Token command %v failed: %s", t.Command, stderr.String())
+	}
+	v := strings.TrimSpace(string(out))
+	if v == "" {
+		return "", errors.Errorf("Token command %v printed nothing", t.Command)
+	}
+	return v, nil
+}
+
+func (a Account) tokenRef() TokenRef {
+	t := TokenRef{Literal: a.AccessToken, Env: a.TokenEnv.String}
+	if a.TokenCommandRaw.Valid {
+		// It is validated on save
+		json.Unmarshal([]byte(a.TokenCommandRaw.String), &t.Command)
+	}
+	return t
+}
+
+// setTokenRef sets t to the account. A literal token is encrypted.
+func (a *Account) setTokenRef(t TokenRef) error {
+	token, err := encryptToken(t.Literal)
+	if err != nil {
+		return err
+	}
+	a.AccessToken = token
+	a.TokenEnv = sql.NullString{String: t.Env, Valid: t.Env != ""}
+	a.TokenCommandRaw = sql.NullString{}
+	if len(t.Command) != 0 {
+		b, err := json.Marshal(t.Command)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		a.TokenCommandRaw = sql.NullString{String: string(b), Valid: true}
+	}
+	return nil
+}
+
+// accountTokenSource resolves the access token of the account lazily, and caches it.
+type accountTokenSource struct {
+	ctx     context.Context
+	account Account
+
+	mu    sync.Mutex
+	token string
+}
+
+func (s *accountTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == "" {
+		t, err := s.account.tokenRef().resolve(s.ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.token = t
+	}
+	return &oauth2.Token{AccessToken: s.token}, nil
+}
+
+// invalidate makes the next Token() call resolve the token again.
+// It returns false if the token is a literal, which never changes.
+func (s *accountTokenSource) invalidate() bool {
+	if s.account.tokenRef().isLiteral() {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+	return true
+}
+
+// reauthTransport retries a request once with a re-resolved token when GitHub responds 401.
+// The token from env, a command or a GitHub App may be rotated while korat-go is running.
+type reauthTransport struct {
+	base   http.RoundTripper
+	source renewableTokenSource
+}
+
+// renewableTokenSource is a token source which can discard the current token.
+type renewableTokenSource interface {
+	oauth2.TokenSource
+	invalidate() bool
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	if !t.source.invalidate() {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.WithContext(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+func ghClient(ctx context.Context, account Account) *github.Client {
+	var ts renewableTokenSource = &accountTokenSource{ctx: ctx, account: account}
+	if account.isApp() {
+		ts = &appTokenSource{ctx: ctx, account: account}
+	}
+	// oauth2.NewClient is not used, because it wraps the source with ReuseTokenSource, which caches the token forever.
+	tc := &http.Client{
+		Transport: &reauthTransport{base: &oauth2.Transport{Source: ts}, source: ts},
+	}
+
+	client := github.NewClient(tc)
+	setApiUrlBase(client, account.ApiUrlBase)
+	return client
+}
+
+// setApiUrlBase points the client to the API of GitHub Enterprise.
+func setApiUrlBase(client *github.Client, apiUrlBase string) {
+	if apiUrlBase == "" || apiUrlBase == defaultApiUrlBase {
+		return
+	}
+	// It is validated on save
+	u, err := url.Parse(strings.TrimSuffix(apiUrlBase, "/") + "/")
+	if err != nil {
+		return
+	}
+	client.BaseURL = u
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// View is a saved filter over a set of channels.
+type View struct {
+	ID          int    `gorm:"primary_key"`
+	DisplayName string `gorm:"column:displayName"`
+	// JSON array of channel IDs
+	ChannelIDsRaw string `gorm:"column:channelIDs"`
+	// LocalQuery
+	Query     string
+	AccountID int `gorm:"column:accountID"`
+}
+
+func (v View) ChannelIDs() ([]int, error) {
+	res := make([]int, 0)
+	err := json.Unmarshal([]byte(v.ChannelIDsRaw), &res)
+	return res, errors.WithStack(err)
+}
+
+// SearchIssuesQuery returns a query to select issues in the view.
+func (v View) SearchIssuesQuery() (*SearchIssuesQuery, error) {
+	channelIDs, err := v.ChannelIDs()
+	if err != nil {
+		return nil, err
+	}
+	lq, err := ParseLocalQuery(v.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &SearchIssuesQuery{
+		perPage:    defaultPerPage,
+		sort:       "updated",
+		order:      "desc",
+		channelIDs: channelIDs,
+		filter:     lq.Filter,
+	}
+	if lq.Sort != "" {
+		q.sort, q.order = lq.Sort, lq.Order
+	}
+	return q, nil
+}
+
+func (v View) UnreadCount(ctx context.Context) (*ViewUnreadCount, error) {
+	res := &ViewUnreadCount{ViewID: v.ID}
+	q, err := v.SearchIssuesQuery()
+	if err != nil {
+		return nil, err
+	}
+	// The view shows only read issues
+	if !q.filter.Unread {
+		return res, nil
+	}
+	q.filter.Read = false
+
+	res.Count, err = CountIssues(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func SelectViewsUnreadCount(ctx context.Context) ([]*ViewUnreadCount, error) {
+	views := make([]View, 0)
+	if err := gormConn.Find(&views).Error; err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return viewsUnreadCount(ctx, views)
+}
+
+// ViewsUnreadCountForChannels returns unread counts of views which include any of the channels.
+func ViewsUnreadCountForChannels(ctx context.Context, channelIDs []int) ([]*ViewUnreadCount, error) {
+	views := make([]View, 0)
+	if err := gormConn.Find(&views).Error; err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	targets := make([]View, 0)
+	for _, v := range views {
+		ids, err := v.ChannelIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if idxIntSlice(channelIDs, id) != -1 {
+				targets = append(targets, v)
+				break
+			}
+		}
+	}
+	return viewsUnreadCount(ctx, targets)
+}
+
+func viewsUnreadCount(ctx context.Context, views []View) ([]*ViewUnreadCount, error) {
+	res := make([]*ViewUnreadCount, 0, len(views))
+	for _, v := range views {
+		cnt, err := v.UnreadCount(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cnt)
+	}
+	return res, nil
+}
+
+// removeChannelsFromViews removes the deleted channels from views.
+// A view is deleted if none of its channels is left, because a view requires at least one channel.
+func removeChannelsFromViews(tx *gorm.DB, channelIDs []int) error {
+	views := make([]View, 0)
+	if err := tx.Find(&views).Error; err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, v := range views {
+		ids, err := v.ChannelIDs()
+		if err != nil {
+			return err
+		}
+		kept := make([]int, 0, len(ids))
+		for _, id := range ids {
+			if idxIntSlice(channelIDs, id) == -1 {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == len(ids) {
+			continue
+		}
+
+		if len(kept) == 0 {
+			err = tx.Exec(`delete from views where id = ?`, v.ID).Error
+		} else {
+			var b []byte
+			b, err = json.Marshal(kept)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			err = tx.Exec(`update views set channelIDs = ? where id = ?`, string(b), v.ID).Error
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// validateView returns an error message if v is invalid.
+func validateView(ctx context.Context, v *View) (string, error) {
+	if v.DisplayName == "" {
+		return "DisplayName is required", nil
+	}
+	if _, err := ParseLocalQuery(v.Query); err != nil {
+		return err.Error(), nil
+	}
+
+	ids := make([]int, 0)
+	if err := json.Unmarshal([]byte(v.ChannelIDsRaw), &ids); err != nil {
+		return "ChannelIDsRaw must be a JSON array of channel IDs", nil
+	}
+	if len(ids) == 0 {
+		return "A view requires at least one channel", nil
+	}
+
+	var cnt int
+	err := gormConn.Model(&Channel{}).Where("id IN (?) AND accountID = ?", ids, v.AccountID).Count(&cnt).Error
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if cnt != len(ids) {
+		return "ChannelIDsRaw must contain channels of the account", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Virtual channels are composed from other channels.
+// They do not search GitHub, and their issues are computed from channel_issues of the source channels.
+var virtualChannelOperators = map[string]string{
+	"union":        " UNION ",
+	"intersection": " INTERSECT ",
+	// The first source channel minus the rest
+	"difference": " EXCEPT ",
+}
+
+func (c Channel) IsVirtual() bool {
+	if !c.System.Valid {
+		return false
+	}
+	_, ok := virtualChannelOperators[c.System.String]
+	return ok
+}
+
+func (c Channel) SourceChannelIDs() ([]int, error) {
+	res := make([]int, 0)
+	if !c.SourceChannelIDsRaw.Valid {
+		return res, nil
+	}
+	err := json.Unmarshal([]byte(c.SourceChannelIDsRaw.String), &res)
+	return res, errors.WithStack(err)
+}
+
+type channelIssuesQueryBuilder struct {
+	channels map[int]Channel
+	visiting map[int]bool
+}
+
+// newChannelIssuesQueryBuilder loads channels from db, which is gormConn or a transaction.
+func newChannelIssuesQueryBuilder(db *gorm.DB) (*channelIssuesQueryBuilder, error) {
+	chs := make([]Channel, 0)
+	if err := db.Find(&chs).Error; err != nil {
+		return nil, errors.WithStack(err)
+	}
+	b := &channelIssuesQueryBuilder{
+		channels: make(map[int]Channel, len(chs)),
+		visiting: make(map[int]bool),
+	}
+	for _, c := range chs {
+		b.channels[c.ID] = c
+	}
+	return b, nil
+}
+
+// ChannelIssuesQuery returns a sub query selecting IDs of issues in any of the channels.
+// It is used as "i.id IN (<sub query>)".
+// nil channelIDs means all channels.
+func ChannelIssuesQuery(ctx context.Context, channelIDs []int) (string, []interface{}, error) {
+	// Virtual channels are composed from other channels, so they do not need to be included.
+	if channelIDs == nil {
+		return "select issueID from channel_issues", nil, nil
+	}
+
+	b, err := newChannelIssuesQueryBuilder(gormConn)
+	if err != nil {
+		return "", nil, err
+	}
+	return b.buildAny(channelIDs)
+}
+
+func (b *channelIssuesQueryBuilder) buildAny(channelIDs []int) (string, []interface{}, error) {
+	real := make([]int, 0)
+	var sqls []string
+	var args []interface{}
+	for _, id := range channelIDs {
+		c, ok := b.channels[id]
+		if !ok || !c.IsVirtual() {
+			real = append(real, id)
+			continue
+		}
+		s, a, err := b.build(c)
+		if err != nil {
+			return "", nil, err
+		}
+		sqls = append(sqls, fmt.Sprintf("select issueID from (%s)", s))
+		args = append(args, a...)
+	}
+	if len(real) != 0 || len(sqls) == 0 {
+		sqls = append([]string{"select issueID from channel_issues where channelID IN (?)"}, sqls...)
+		args = append([]interface{}{real}, args...)
+	}
+
+	return strings.Join(sqls, " UNION "), args, nil
+}
+
+func (b *channelIssuesQueryBuilder) build(c Channel) (string, []interface{}, error) {
+	if !c.IsVirtual() {
+		return "select issueID from channel_issues where channelID = ?", []interface{}{c.ID}, nil
+	}
+	if b.visiting[c.ID] {
+		return "", nil, errors.Errorf("channel %d refers to itself", c.ID)
+	}
+	b.visiting[c.ID] = true
+	defer delete(b.visiting, c.ID)
+
+	ids, err := c.SourceChannelIDs()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(ids) == 0 {
+		return "", nil, errors.Errorf("virtual channel %d does not have source channels", c.ID)
+	}
+
+	sqls := make([]string, len(ids))
+	var args []interface{}
+	for idx, id := range ids {
+		src, ok := b.channels[id]
+		if !ok {
+			return "", nil, errors.Errorf("source channel %d of channel %d does not exist", id, c.ID)
+		}
+		s, a, err := b.build(src)
+		if err != nil {
+			return "", nil, err
+		}
+		sqls[idx] = fmt.Sprintf("select issueID from (%s)", s)
+		args = append(args, a...)
+	}
+
+	return strings.Join(sqls, virtualChannelOperators[c.System.String]), args, nil
+}
+
+// hasVirtualChannel returns true if any of the channels is a virtual channel.
+func hasVirtualChannel(channelIDs []int) (bool, error) {
+	chs := make([]Channel, 0)
+	if err := gormConn.Where("id IN (?)", channelIDs).Find(&chs).Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+	for _, c := range chs {
+		if c.IsVirtual() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// dependsOn returns true if c is composed from any of the channels, directly or indirectly.
+func (b *channelIssuesQueryBuilder) dependsOn(c Channel, channelIDs []int) (bool, error) {
+	if !c.IsVirtual() {
+		return false, nil
+	}
+	if b.visiting[c.ID] {
+		return false, errors.Errorf("channel %d refers to itself", c.ID)
+	}
+	b.visiting[c.ID] = true
+	defer delete(b.visiting, c.ID)
+
+	ids, err := c.SourceChannelIDs()
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if idxIntSlice(channelIDs, id) != -1 {
+			return true, nil
+		}
+		if src, ok := b.channels[id]; ok {
+			ok, err := b.dependsOn(src, channelIDs)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// VirtualChannelsUnreadCount returns unread counts of virtual channels.
+// If channelIDs is not nil, it returns only virtual channels composed from the channels.
+func VirtualChannelsUnreadCount(ctx context.Context, channelIDs []int) ([]*UnreadCount, error) {
+	b, err := newChannelIssuesQueryBuilder(gormConn)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*UnreadCount, 0)
+	for _, c := range b.channels {
+		if !c.IsVirtual() {
+			continue
+		}
+		if channelIDs != nil {
+			ok, err := b.dependsOn(c, channelIDs)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		sub, args, err := b.build(c)
+		if err != nil {
+			return nil, err
+		}
+		cnt := &UnreadCount{ChannelID: c.ID}
+		err = gormConn.Raw(fmt.Sprintf(`
+			select count(*)
+			from issues
+			where alreadyRead = 0 AND id NOT IN (select issueID from snoozes) AND id IN (%s)
+		`, sub), args...).Row().Scan(&cnt.Count)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, cnt)
+	}
+	return res, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// accountWorkers holds a context for workers of each account.
+// Cancelling it stops fetching issues and determining merged pull requests of the account.
+var accountWorkers = struct {
+	mu      sync.Mutex
+	parent  context.Context
+	ctxs    map[int]context.Context
+	cancels map[int]context.CancelFunc
+}{
+	parent:  context.Background(),
+	ctxs:    make(map[int]context.Context),
+	cancels: make(map[int]context.CancelFunc),
+}
+
+// initAccountWorkers sets the parent context of workers started after this call.
+func initAccountWorkers(ctx context.Context) {
+	accountWorkers.mu.Lock()
+	defer accountWorkers.mu.Unlock()
+	accountWorkers.parent = ctx
+}
+
+// accountWorkerContext returns the context shared by workers of the account.
+func accountWorkerContext(accountID int) context.Context {
+	accountWorkers.mu.Lock()
+	defer accountWorkers.mu.Unlock()
+
+	if ctx, ok := accountWorkers.ctxs[accountID]; ok {
+		return ctx
+	}
+	ctx, cancel := context.WithCancel(accountWorkers.parent)
+	accountWorkers.ctxs[accountID] = ctx
+	accountWorkers.cancels[accountID] = cancel
+	return ctx
+}
+
+// StopAccountWorkers stops all workers of the account.
+// Workers started after this call get a new context.
+func StopAccountWorkers(accountID int) {
+	accountWorkers.mu.Lock()
+	defer accountWorkers.mu.Unlock()
+	stopAccountWorkersLocked(accountID)
+}
+
+func stopAccountWorkersLocked(accountID int) {
+	if cancel, ok := accountWorkers.cancels[accountID]; ok {
+		cancel()
+	}
+	delete(accountWorkers.ctxs, accountID)
+	delete(accountWorkers.cancels, accountID)
+}
+
+// renewAccountWorkerContext stops workers of the account, and returns a new context for them.
+// They are done under one lock, so concurrent restarts do not share a context.
+func renewAccountWorkerContext(accountID int) context.Context {
+	accountWorkers.mu.Lock()
+	defer accountWorkers.mu.Unlock()
+	stopAccountWorkersLocked(accountID)
+
+	ctx, cancel := context.WithCancel(accountWorkers.parent)
+	accountWorkers.ctxs[accountID] = ctx
+	accountWorkers.cancels[accountID] = cancel
+	return ctx
+}
+
+// RestartAccountWorkers stops workers of the account, and starts them with the current rows.
+// Queries of system channels are built in the background because they call the API.
+func RestartAccountWorkers(accountID int) {
+	ctx := renewAccountWorkerContext(accountID)
+	go func() {
+		if err := startAccountWorkers(ctx, accountID); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}()
+}
+
+// RestartAllAccountWorkers restarts workers of all accounts. Workers of deleted accounts are stopped.
+func RestartAllAccountWorkers() error {
+	accountWorkers.mu.Lock()
+	ids := make([]int, 0, len(accountWorkers.cancels))
+	for id := range accountWorkers.cancels {
+		ids = append(ids, id)
+	}
+	accountWorkers.mu.Unlock()
+	for _, id := range ids {
+		StopAccountWorkers(id)
+	}
+
+	accounts := make([]Account, 0)
+	if err := gormConn.Find(&accounts).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	for _, a := range accounts {
+		RestartAccountWorkers(a.ID)
+	}
+	return nil
+}
+
+func startAccountWorkers(ctx context.Context, accountID int) error {
+	a := Account{}
+	if err := gormConn.Where("id = ?", accountID).First(&a).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	chs := make([]Channel, 0)
+	if err := gormConn.Preload("Account").Where("accountID = ?", accountID).Find(&chs).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	qs, err := BuildActualQuery(ctx, chs)
+	if err != nil {
+		return err
+	}
+	// Restarted again while building queries
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	for _, q := range qs {
+		go startFetchIssuesWorker(ctx, q)
+	}
+	go startDetermineMergedWorker(ctx, a)
+	return nil
+}